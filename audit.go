@@ -0,0 +1,237 @@
+package k8
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// The outcomes of the audit records.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+const redactedValue = "******"
+
+// AuditRecord records who ran which method, with what arguments, when and with what outcome.
+type AuditRecord struct {
+	Time    time.Time   `json:"time"`
+	User    string      `json:"user,omitempty"`
+	Address string      `json:"address,omitempty"`
+	Method  string      `json:"method"`
+	Args    interface{} `json:"args,omitempty"`
+	Code    string      `json:"code,omitempty"`
+	// CodeHash is the SHA-256 of Code in hex.
+	CodeHash string  `json:"code_sha256,omitempty"`
+	Duration float64 `json:"duration_ms"`
+	Outcome  string  `json:"outcome"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// An AuditSink stores the audit records.
+type AuditSink interface {
+	Write(record *AuditRecord) error
+}
+
+// AuditSinkFunc is an adapter to allow the use of ordinary functions as an AuditSink.
+type AuditSinkFunc func(record *AuditRecord) error
+
+func (fn AuditSinkFunc) Write(record *AuditRecord) error {
+	return fn(record)
+}
+
+// FileAuditSink writes the records to a file in the JSON lines format, the file is rotated
+// when it reaches the max size.
+type FileAuditSink struct {
+	mu     sync.Mutex
+	logger *lumberjack.Logger
+}
+
+// NewFileAuditSink creates a FileAuditSink, maxSize is in megabytes and maxAge is in days,
+// the old files are kept forever if maxBackups and maxAge are 0.
+func NewFileAuditSink(filename string, maxSize, maxBackups, maxAge int) *FileAuditSink {
+	return &FileAuditSink{
+		logger: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    maxSize,
+			MaxBackups: maxBackups,
+			MaxAge:     maxAge,
+		},
+	}
+}
+
+func (sink *FileAuditSink) Write(record *AuditRecord) error {
+	bs, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	_, err = sink.logger.Write(bs)
+	return err
+}
+
+func (sink *FileAuditSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.logger.Close()
+}
+
+// AuditUserFunc returns the user of the request.
+type AuditUserFunc func(c *loong.Context) string
+
+// An Auditor creates the audit records of the invocations, a nil *Auditor records nothing.
+type Auditor struct {
+	Sink     AuditSink
+	UserFunc AuditUserFunc
+	Logger   log.Logger
+
+	// closer is the sink opened by newAuditor, it is closed with the application.
+	closer io.Closer
+}
+
+// Snapshot copies the arguments of an invocation for Record, it is called before the
+// invocation, so the record holds what the caller sent even if the script changes them.
+func (auditor *Auditor) Snapshot(args interface{}) interface{} {
+	if auditor == nil || auditor.Sink == nil {
+		return nil
+	}
+	return snapshotArgs(args)
+}
+
+func (auditor *Auditor) close() error {
+	if auditor == nil || auditor.closer == nil {
+		return nil
+	}
+	return auditor.closer.Close()
+}
+
+// Record writes the record of an invocation, args is the snapshot taken by Snapshot. The fields listed in meta.redact of the method
+// are replaced in the recorded arguments, e.g. redact: ["password", "auth.token"].
+func (auditor *Auditor) Record(c *loong.Context, method string, meta map[string]interface{},
+	args interface{}, code string, started time.Time, err error) {
+	if auditor == nil || auditor.Sink == nil {
+		return
+	}
+
+	if v, ok := args.(goja.Value); ok && v != nil {
+		args = v.Export()
+	}
+
+	record := &AuditRecord{
+		Time:     started,
+		Method:   method,
+		Args:     redactArgs(args, redactFields(meta)),
+		Code:     code,
+		Duration: float64(time.Since(started)) / float64(time.Millisecond),
+		Outcome:  AuditSuccess,
+	}
+	if code != "" {
+		sum := sha256.Sum256([]byte(code))
+		record.CodeHash = hex.EncodeToString(sum[:])
+	}
+	if c != nil {
+		record.Address = c.RealIP()
+		if auditor.UserFunc != nil {
+			record.User = auditor.UserFunc(c)
+		}
+	}
+	if err != nil {
+		record.Outcome = AuditFailure
+		record.Error = err.Error()
+	}
+
+	if e := auditor.Sink.Write(record); e != nil && auditor.Logger != nil {
+		auditor.Logger.Warn("write audit record fail", log.String("method", method), log.Error(e))
+	}
+}
+
+// snapshotArgs returns a deep copy of the exported arguments, the maps and the slices
+// passed to a method are shared with the script.
+func snapshotArgs(args interface{}) interface{} {
+	if v, ok := args.(goja.Value); ok && v != nil {
+		args = v.Export()
+	}
+	switch v := args.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			copied[k] = snapshotArgs(e)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, e := range v {
+			copied[i] = snapshotArgs(e)
+		}
+		return copied
+	case []string:
+		return append([]string(nil), v...)
+	}
+	return args
+}
+
+func redactFields(meta map[string]interface{}) []string {
+	if meta == nil {
+		return nil
+	}
+	switch v := meta["redact"].(type) {
+	case []interface{}:
+		fields := make([]string, 0, len(v))
+		for _, field := range v {
+			fields = append(fields, fmt.Sprint(field))
+		}
+		return fields
+	case []string:
+		return v
+	case string:
+		return []string{v}
+	}
+	return nil
+}
+
+// redactArgs returns a copy of args in which the fields are replaced, a field may be a
+// path separated by dot.
+func redactArgs(args interface{}, fields []string) interface{} {
+	if len(fields) == 0 {
+		return args
+	}
+	values, ok := args.(map[string]interface{})
+	if !ok {
+		return args
+	}
+
+	copied := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		copied[k] = v
+	}
+	for _, field := range fields {
+		name, rest := field, ""
+		if idx := strings.IndexByte(field, '.'); idx >= 0 {
+			name, rest = field[:idx], field[idx+1:]
+		}
+		v, ok := copied[name]
+		if !ok {
+			continue
+		}
+		if rest == "" {
+			copied[name] = redactedValue
+		} else {
+			copied[name] = redactArgs(v, []string{rest})
+		}
+	}
+	return copied
+}
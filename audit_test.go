@@ -0,0 +1,157 @@
+package k8
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactArgs(t *testing.T) {
+	args := map[string]interface{}{
+		"user":     "admin",
+		"password": "secret",
+		"auth": map[string]interface{}{
+			"token": "abc",
+			"type":  "bearer",
+		},
+	}
+	meta := map[string]interface{}{
+		"redact": []interface{}{"password", "auth.token", "missing.field"},
+	}
+
+	redacted := redactArgs(args, redactFields(meta))
+	assert.Equal(t, map[string]interface{}{
+		"user":     "admin",
+		"password": redactedValue,
+		"auth": map[string]interface{}{
+			"token": redactedValue,
+			"type":  "bearer",
+		},
+	}, redacted)
+
+	// the original arguments are not changed.
+	assert.Equal(t, "secret", args["password"])
+	assert.Equal(t, "abc", args["auth"].(map[string]interface{})["token"])
+}
+
+func TestAuditorRecord(t *testing.T) {
+	var records []*AuditRecord
+	auditor := &Auditor{
+		Sink: AuditSinkFunc(func(record *AuditRecord) error {
+			records = append(records, record)
+			return nil
+		}),
+	}
+
+	meta := map[string]interface{}{"redact": []interface{}{"password"}}
+	auditor.Record(nil, "login", meta, map[string]interface{}{"password": "secret"}, "", time.Now(), nil)
+	auditor.Record(nil, "_/run_script", nil, nil, "exports.default = function() {}", time.Now(), errors.New("boom"))
+
+	require.Len(t, records, 2)
+	assert.Equal(t, "login", records[0].Method)
+	assert.Equal(t, AuditSuccess, records[0].Outcome)
+	assert.Equal(t, map[string]interface{}{"password": redactedValue}, records[0].Args)
+	assert.Equal(t, AuditFailure, records[1].Outcome)
+	assert.Equal(t, "boom", records[1].Error)
+	assert.Equal(t, "exports.default = function() {}", records[1].Code)
+
+	var nilAuditor *Auditor
+	nilAuditor.Record(nil, "login", nil, nil, "", time.Now(), nil)
+}
+
+func TestAuditorSnapshot(t *testing.T) {
+	var records []*AuditRecord
+	auditor := &Auditor{
+		Sink: AuditSinkFunc(func(record *AuditRecord) error {
+			records = append(records, record)
+			return nil
+		}),
+	}
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/change.js", `module.exports.meta = {id: 'change'};
+		module.exports.default = function(args) {
+			args.name = "changed";
+			args.tags[0] = "changed";
+			args.nested.a = "changed";
+			return 1;
+		}`))
+	ctx := context.Background()
+	r, err := b.Build(ctx, nil)
+	require.NoError(t, err)
+
+	sent := map[string]interface{}{
+		"name":   "a",
+		"tags":   []interface{}{"b"},
+		"nested": map[string]interface{}{"a": "c"},
+	}
+
+	t.Run("Object", func(t *testing.T) {
+		records = nil
+		args := r.Runtime.NewObject()
+		args.Set("name", "a")
+		args.Set("tags", []string{"b"})
+		nested := r.Runtime.NewObject()
+		nested.Set("a", "c")
+		args.Set("nested", nested)
+
+		recorded := auditor.Snapshot(args)
+		_, err := r.RunMethod(ctx, "change", args)
+		require.NoError(t, err)
+		auditor.Record(nil, "change", nil, recorded, "", time.Now(), nil)
+
+		require.Len(t, records, 1)
+		assert.Equal(t, map[string]interface{}{
+			"name":   "a",
+			"tags":   []string{"b"},
+			"nested": map[string]interface{}{"a": "c"},
+		}, records[0].Args)
+	})
+
+	t.Run("RPC", func(t *testing.T) {
+		records = nil
+		r.runRPC(ctx, []byte(`{"jsonrpc": "2.0", "method": "change",
+			"params": {"name": "a", "tags": ["b"], "nested": {"a": "c"}}, "id": 1}`),
+			func(method string, args interface{}, started time.Time, err error) {
+				auditor.Record(nil, method, nil, args, "", started, err)
+			})
+
+		require.Len(t, records, 1)
+		assert.Equal(t, sent, records[0].Args)
+	})
+
+	var nilAuditor *Auditor
+	assert.Nil(t, nilAuditor.Snapshot(sent))
+}
+
+func TestFileAuditSink(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	sink := NewFileAuditSink(filename, 1, 1, 0)
+	for _, method := range []string{"a", "b"} {
+		require.NoError(t, sink.Write(&AuditRecord{Method: method, Outcome: AuditSuccess}))
+	}
+	require.NoError(t, sink.Close())
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var methods []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		methods = append(methods, record.Method)
+	}
+	assert.Equal(t, []string{"a", "b"}, methods)
+}
@@ -0,0 +1,185 @@
+package k8
+
+import (
+	"encoding/json"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+)
+
+// ErrResourceExhausted is the cause of the errors returned when an invocation exceeds
+// its budget.
+//
+//nolint:gochecknoglobals
+var ErrResourceExhausted = errors.New("resource exhausted")
+
+// ErrHeapGuard is the cause of the errors returned when an invocation is interrupted by
+// the heap guard.
+//
+//nolint:gochecknoglobals
+var ErrHeapGuard = errors.New("interrupted by the heap guard")
+
+// The resources limited by a Budget.
+const (
+	ResourceResult = "result"
+)
+
+// heapMetric is the bytes occupied by the heap objects, including the unreachable ones
+// which are not swept yet.
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// A Budget limits the resources of the invocations, zero means unlimited.
+//
+// Result is checked for each invocation. HeapGuard isn't a limit of an invocation: Go
+// can't tell the memory allocated by an invocation, so the heap of the whole process is
+// sampled while a method is running and all running methods are interrupted once it is
+// larger than the guard, even if the memory is allocated by other requests. A short burst
+// between two samples may be missed.
+type Budget struct {
+	// HeapGuard is the maximum size of the heap of the process in bytes.
+	HeapGuard int64
+	// Result is the maximum size of the result of an invocation in bytes, encoded in JSON.
+	Result int64
+	// Interval is the period of sampling the heap for HeapGuard, it is 10ms by default.
+	Interval time.Duration
+}
+
+// A ResourceExhaustedError is returned when an invocation exceeds its budget, the runner
+// is discarded after it.
+type ResourceExhaustedError struct {
+	Method   string
+	Resource string
+	Limit    int64
+	Used     int64
+}
+
+func (e *ResourceExhaustedError) Error() string {
+	return "resource exhausted: method '" + e.Method + "' used " + strconv.FormatInt(e.Used, 10) +
+		" bytes of " + e.Resource + ", the limit is " + strconv.FormatInt(e.Limit, 10) + " bytes"
+}
+
+// Cause returns ErrResourceExhausted.
+func (e *ResourceExhaustedError) Cause() error {
+	return ErrResourceExhausted
+}
+
+// Is returns true for ErrResourceExhausted.
+func (e *ResourceExhaustedError) Is(target error) bool {
+	return target == ErrResourceExhausted
+}
+
+// A HeapGuardError is returned when an invocation is interrupted because the heap of the
+// process is larger than Budget.HeapGuard, the memory isn't necessarily allocated by the
+// invocation. The runner is discarded after it.
+type HeapGuardError struct {
+	Limit int64
+	Used  int64
+}
+
+func (e *HeapGuardError) Error() string {
+	return "interrupted by the heap guard: the heap of the process is " + strconv.FormatInt(e.Used, 10) +
+		" bytes, the limit is " + strconv.FormatInt(e.Limit, 10) + " bytes"
+}
+
+// Cause returns ErrHeapGuard.
+func (e *HeapGuardError) Cause() error {
+	return ErrHeapGuard
+}
+
+// Is returns true for ErrHeapGuard.
+func (e *HeapGuardError) Is(target error) bool {
+	return target == ErrHeapGuard
+}
+
+func heapObjects() int64 {
+	samples := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(samples[0].Value.Uint64())
+}
+
+// heapWatcher interrupts the runtime if the heap of the process is larger than the guard.
+type heapWatcher struct {
+	rt   *gojs.Runtime
+	done chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	err  *HeapGuardError
+}
+
+// watchHeap starts to sample the heap, it returns nil if there is no heap guard.
+func (runner *Runner) watchHeap() *heapWatcher {
+	budget := runner.Budget
+	if budget.HeapGuard <= 0 {
+		return nil
+	}
+	interval := budget.Interval
+	if interval <= 0 {
+		interval = 10 * time.Millisecond
+	}
+
+	w := &heapWatcher{rt: runner.Runtime, done: make(chan struct{})}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				if used := heapObjects(); used > budget.HeapGuard {
+					err := &HeapGuardError{Limit: budget.HeapGuard, Used: used}
+					w.mu.Lock()
+					w.err = err
+					w.mu.Unlock()
+					w.rt.Interrupt(err)
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// stop stops the sampling and returns the error if the heap is larger than the guard. The
+// interrupt is cleared, it is still pending if the method returned before it is seen.
+func (w *heapWatcher) stop() error {
+	if w == nil {
+		return nil
+	}
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		return nil
+	}
+	w.rt.ClearInterrupt()
+	return w.err
+}
+
+// checkResult returns an error if the result is larger than the budget.
+func (runner *Runner) checkResult(fnname string, result interface{}) error {
+	if runner.Budget.Result <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		// the result which cannot be encoded is reported by the caller.
+		return nil
+	}
+	if size := int64(len(data)); size > runner.Budget.Result {
+		return &ResourceExhaustedError{Method: fnname, Resource: ResourceResult, Limit: runner.Budget.Result, Used: size}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package k8
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildBudget(t *testing.T, budget Budget, code string) *Builder {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.SetMetrics(NewMetrics())
+	b.SetBudget(budget)
+	require.NoError(t, b.Compile("/a.js", code))
+	return b
+}
+
+func TestBudgetHeapGuard(t *testing.T) {
+	b := buildBudget(t, Budget{HeapGuard: heapObjects() + 8<<20, Interval: time.Millisecond}, `exports.meta = {id: "a"};
+exports.default = function(alloc) {
+	if (!alloc) {
+		var deadline = Date.now() + 2000;
+		while (Date.now() < deadline) {}
+		return 0;
+	}
+	var items = [];
+	for (var i = 0; i < 200000; i++) {
+		items.push("x".repeat(1024) + i);
+	}
+	return items.length;
+};`)
+	idle, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+	heavy, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	// only one invocation allocates, the guard can't tell them apart, so neither of them
+	// is blamed for exhausting its budget.
+	var idleErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, idleErr = idle.RunMethod(context.Background(), "a", false)
+	}()
+	_, err = heavy.RunMethod(context.Background(), "a", true)
+	<-done
+
+	require.Error(t, err)
+	assert.Equal(t, ErrHeapGuard, errors.Cause(err))
+	guarded, ok := err.(*HeapGuardError)
+	if assert.True(t, ok) {
+		assert.Equal(t, b.budget.HeapGuard, guarded.Limit)
+	}
+	assert.True(t, heavy.Exhausted())
+	if idleErr != nil {
+		assert.Equal(t, ErrHeapGuard, errors.Cause(idleErr))
+		assert.True(t, idle.Exhausted())
+	}
+	for _, err := range []error{err, idleErr} {
+		_, ok := err.(*ResourceExhaustedError)
+		assert.False(t, ok)
+	}
+
+	// the interrupt is cleared, so the runtime isn't interrupted again.
+	_, err = heavy.Runtime.RunString(context.Background(), "1 + 1")
+	assert.NoError(t, err)
+}
+
+func TestBudgetResult(t *testing.T) {
+	b := buildBudget(t, Budget{Result: 100}, `exports.meta = {id: "a"};
+exports.default = function(n) { return "x".repeat(n); };`)
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	v, err := r.RunMethod(context.Background(), "a", 10)
+	require.NoError(t, err)
+	assert.Equal(t, "xxxxxxxxxx", v)
+	assert.False(t, r.Exhausted())
+
+	_, err = r.RunMethod(context.Background(), "a", 1000)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "resource exhausted: method 'a' used 1002 bytes of result, the limit is 100 bytes")
+	assert.True(t, r.Exhausted())
+}
+
+func TestPoolDiscardExhausted(t *testing.T) {
+	b := buildBudget(t, Budget{Result: 100}, `exports.meta = {id: "a"};
+exports.default = function(n) { return "x".repeat(n); };`)
+	b.opts.IncludeSystemEnvVars = true
+	d, err := newDeployment(context.Background(), b, 1)
+	require.NoError(t, err)
+
+	r, err := d.pool.Get(context.Background())
+	require.NoError(t, err)
+	_, err = r.RunMethod(context.Background(), "a", 1000)
+	require.Error(t, err)
+	d.pool.Put(r)
+
+	// the runner is replaced in background while the requests build their own runners,
+	// run it with -race.
+	_, err = b.BuildString(context.Background(), nil, `exports.default = function() {};`)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	replaced, err := d.pool.Get(ctx)
+	require.NoError(t, err)
+	assert.NotSame(t, r, replaced)
+	assert.False(t, replaced.Exhausted())
+	assert.Equal(t, 1, d.pool.Size())
+}
@@ -3,10 +3,13 @@ package k8
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/dop251/goja"
 	"github.com/pkg/errors"
 	"github.com/runner-mei/gojs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //nolint:gochecknoglobals
@@ -26,37 +29,178 @@ func NewBuilder(opts *gojs.RuntimeOptions) (*Builder, error) {
 		c:          gojs.NewCompiler(),
 		opts:       opts,
 		compatMode: compatMode,
+		metrics:    NewMetrics(),
+		modules: map[string]Module{
+			"trace":    ModuleFunc(traceModule),
+			"response": ModuleFunc(responseModule),
+			"log":      ModuleFunc(logModule),
+		},
 	}, nil
 }
 
 type Program struct {
 	Filename string
-	// Source   string
-	Program *goja.Program
+	Source   string
+	Program  *goja.Program
 }
 
 // A Builder is a self-contained bundle of scripts and resources.
 type Builder struct {
-	programs   []Program
-	c          *gojs.Compiler
-	opts       *gojs.RuntimeOptions
-	compatMode gojs.CompatibilityMode
+	programs       []Program
+	c              *gojs.Compiler
+	opts           *gojs.RuntimeOptions
+	compatMode     gojs.CompatibilityMode
+	metrics        *Metrics
+	tracerProvider trace.TracerProvider
+	modules        map[string]Module
+	sourceMaps     sourceMaps
+	strict         bool
+	budget         Budget
+	determ         *Deterministic
+	// limits are the capabilities of the bundles, keyed by the filenames of the entries.
+	limits map[string]*Capabilities
+	// failures are the scripts which failed to compile, they are reported by Check.
+	failures []Problem
 }
 
+// TracerProvider returns the tracer provider of the runners built by the builder, it is
+// the global one of OpenTelemetry if no provider is set.
+func (b *Builder) TracerProvider() trace.TracerProvider {
+	if b.tracerProvider == nil {
+		return otel.GetTracerProvider()
+	}
+	return b.tracerProvider
+}
+
+// SetTracerProvider replaces the tracer provider, it must be called before Build.
+func (b *Builder) SetTracerProvider(provider trace.TracerProvider) {
+	b.tracerProvider = provider
+}
+
+// Tracer returns the tracer of the spans of the builder.
+func (b *Builder) Tracer() trace.Tracer {
+	return b.TracerProvider().Tracer(instrumentationName)
+}
+
+// runnerTracer returns nil if no tracer provider is set, the runners use the provider of
+// the parent span or the global one then.
+func (b *Builder) runnerTracer() trace.Tracer {
+	if b.tracerProvider == nil {
+		return nil
+	}
+	return b.Tracer()
+}
+
+// SetSandboxStrict denies the resources which aren't declared in the meta description
+// of a method, by default only the declared kinds of resources are restricted. It must be
+// called before Build.
+func (b *Builder) SetSandboxStrict(strict bool) {
+	b.strict = strict
+}
+
+// limitCapabilities restricts the methods in the file to the capabilities, the methods
+// cannot access more even if they declare it. It must be called before Build.
+func (b *Builder) limitCapabilities(filename string, limit *Capabilities) {
+	if b.limits == nil {
+		b.limits = map[string]*Capabilities{}
+	}
+	b.limits[filename] = limit
+}
+
+// capabilitiesOf returns the capabilities of the method in the file.
+func (b *Builder) capabilitiesOf(filename string, meta map[string]interface{}) *Capabilities {
+	return capabilitiesOf(meta, b.strict).withLimit(b.limits[filename])
+}
+
+// SetBudget limits the resources used by each invocation of the runners, it must be
+// called before Build.
+func (b *Builder) SetBudget(budget Budget) {
+	b.budget = budget
+}
+
+// SetDeterministic runs all invocations of the runners in the deterministic mode, it
+// must be called before Build.
+func (b *Builder) SetDeterministic(d *Deterministic) {
+	b.determ = d
+}
+
+// Metrics returns the metrics of the builder and the runners built by it.
+func (b *Builder) Metrics() *Metrics {
+	return b.metrics
+}
+
+// SetMetrics replaces the metrics, it must be called before Compile and Build.
+func (b *Builder) SetMetrics(metrics *Metrics) {
+	b.metrics = metrics
+}
+
+// Compile compiles the script, the TypeScript files (*.ts) are transpiled to JavaScript
+// first, and the positions in the errors are mapped back to the TypeScript sources. The
+// error is also reported by Check, so the other scripts can still be compiled and all
+// problems are reported at once.
 func (b *Builder) Compile(filename string, code string) error {
-	// Compile sources, both ES5 and ES6 are supported.
-	pgm, _, err := b.c.Compile(code, filename, "", "", true, b.compatMode)
+	started := time.Now()
+	pgm, err := b.compile(filename, code)
+	b.metrics.observeCompile(time.Since(started), err)
 	if err != nil {
+		b.failures = append(b.failures, problemOf(filename, err))
 		return err
 	}
 
 	b.programs = append(b.programs, Program{
 		Filename: filename,
+		Source:   code,
 		Program:  pgm,
 	})
 	return nil
 }
 
+// SetSourceMap sets the source map of the file, the positions in the errors of the file
+// are mapped back to the original sources. It must be called before Compile, the source
+// map embedded in the "//# sourceMappingURL=data:..." comment is used if it isn't called.
+func (b *Builder) SetSourceMap(filename string, data []byte) error {
+	sm, err := parseSourceMap(data)
+	if err != nil {
+		return err
+	}
+	if b.sourceMaps == nil {
+		b.sourceMaps = sourceMaps{}
+	}
+	b.sourceMaps[filename] = sm
+	return nil
+}
+
+func (b *Builder) compile(filename string, code string) (*goja.Program, error) {
+	if isTypeScript(filename) {
+		js, sm, err := transpileTypeScript(filename, code, b.compatMode)
+		if err != nil {
+			return nil, err
+		}
+		if b.sourceMaps == nil {
+			b.sourceMaps = sourceMaps{}
+		}
+		b.sourceMaps[filename] = sm
+		code = js
+	} else if _, ok := b.sourceMaps[filename]; !ok {
+		data, inline, err := decodeDataURL(sourceMappingURL(code))
+		if err != nil {
+			return nil, errors.Wrap(err, filename)
+		}
+		if inline {
+			if err := b.SetSourceMap(filename, data); err != nil {
+				return nil, errors.Wrap(err, filename)
+			}
+		}
+	}
+
+	// Compile sources, both ES5 and ES6 are supported.
+	pgm, _, err := b.c.Compile(code, filename, "", "", true, b.compatMode)
+	if err != nil {
+		return nil, b.sourceMaps.rewrite(err)
+	}
+	return pgm, nil
+}
+
 func (b *Builder) BuildString(ctx context.Context, rt *gojs.Runtime, script string) (*Runner, error) {
 	pgm, _, err := b.c.Compile(script, "_default_", "", "", true, b.compatMode)
 	if err != nil {
@@ -70,24 +214,49 @@ func (b *Builder) BuildString(ctx context.Context, rt *gojs.Runtime, script stri
 }
 
 func (b *Builder) Build(ctx context.Context, rt *gojs.Runtime) (*Runner, error) {
-	return b.build(ctx, rt, b.programs)
+	return b.build(ctx, rt, b.methodPrograms())
+}
+
+// methodPrograms returns the programs of the methods, the test scripts are excluded.
+func (b *Builder) methodPrograms() []Program {
+	programs := make([]Program, 0, len(b.programs))
+	for _, pgm := range b.programs {
+		if !IsTestScript(pgm.Filename) {
+			programs = append(programs, pgm)
+		}
+	}
+	return programs
+}
+
+// newRuntime creates a runtime with a copy of the options, gojs.NewWith writes the
+// environment variables into opts.Env and the runtimes may be created concurrently.
+func (b *Builder) newRuntime() (*gojs.Runtime, error) {
+	opts := *b.opts
+	opts.Env = make(map[string]string, len(b.opts.Env))
+	for k, v := range b.opts.Env {
+		opts.Env[k] = v
+	}
+	return gojs.NewWith(&opts)
 }
 
 func (b *Builder) build(ctx context.Context, rt *gojs.Runtime, programs []Program) (*Runner, error) {
 	if rt == nil {
-		r, err := gojs.NewWith(b.opts)
+		r, err := b.newRuntime()
 		if err != nil {
 			return nil, err
 		}
 		rt = r
 	}
+	installConsole(rt)
+	installModules(rt, b.modules)
+	env := guardEnv(rt)
 
 	methods := map[string]Method{}
 	if len(programs) == 1 {
-		name, meta, method, err := b.createMethod(ctx, rt, programs[0].Filename,
+		name, meta, method, err := b.loadMethod(ctx, rt, env, programs[0].Filename,
 			programs[0].Program, true)
 		if err != nil {
-			return nil, err
+			return nil, b.sourceMaps.rewrite(err)
 		}
 		if name == "" {
 			name = "default"
@@ -98,48 +267,127 @@ func (b *Builder) build(ctx context.Context, rt *gojs.Runtime, programs []Progra
 			}
 		}
 
-		methods[name] = Method{
-			Meta:   meta,
-			Method: method,
+		key := methodKey(name, meta)
+		methods[key] = Method{
+			Meta:         meta,
+			Method:       method,
+			Capabilities: b.capabilitiesOf(programs[0].Filename, meta),
+		}
+		if err := addDefaultVersions(methods, map[string]string{key: programs[0].Filename}); err != nil {
+			return nil, err
 		}
 
 		// A Runner is a self-contained instance of a Bundle.
-		return &Runner{
-			Runtime: rt,
-			Default: method,
-			Methods: methods,
-		}, nil
+		runner := &Runner{
+			Runtime:       rt,
+			Default:       method,
+			Methods:       methods,
+			Metrics:       b.metrics,
+			Tracer:        b.runnerTracer(),
+			Budget:        b.budget,
+			Deterministic: b.determ,
+
+			sourceMaps: b.sourceMaps,
+		}
+		runner.installCall()
+		return runner, nil
 	}
 
+	filenames := map[string]string{}
 	for _, pgm := range programs {
-		name, meta, method, err := b.createMethod(ctx, rt,
+		name, meta, method, err := b.loadMethod(ctx, rt, env,
 			pgm.Filename, pgm.Program, false)
 		if err != nil {
-			return nil, err
+			return nil, b.sourceMaps.rewrite(err)
+		}
+		key := methodKey(name, meta)
+		if other, ok := filenames[key]; ok {
+			replace, ok := keepOverride(methods[key].Meta, meta)
+			if !ok {
+				return nil, errors.New("duplicate id '" + key + "' in " + other + " and " + pgm.Filename +
+					", " + duplicateHint(meta))
+			}
+			if !replace {
+				continue
+			}
 		}
-		methods[name] = Method{
-			Meta:   meta,
-			Method: method,
+		filenames[key] = pgm.Filename
+		methods[key] = Method{
+			Meta:         meta,
+			Method:       method,
+			Capabilities: b.capabilitiesOf(pgm.Filename, meta),
 		}
 	}
+	if err := addDefaultVersions(methods, filenames); err != nil {
+		return nil, err
+	}
 
 	// A Runner is a self-contained instance of a Bundle.
-	return &Runner{
-		Runtime: rt,
-		Methods: methods,
-	}, nil
+	runner := &Runner{
+		Runtime:       rt,
+		Methods:       methods,
+		Metrics:       b.metrics,
+		Tracer:        b.runnerTracer(),
+		Budget:        b.budget,
+		Deterministic: b.determ,
+
+		sourceMaps: b.sourceMaps,
+	}
+	runner.installCall()
+	return runner, nil
+}
+
+// isOverride returns true if the method replaces the method with the same id.
+func isOverride(meta map[string]interface{}) bool {
+	overrides, _ := meta["overrides"].(bool)
+	return overrides
+}
+
+// keepOverride decides which of two methods with the same id is kept, the one declared
+// with "overrides: true" replaces the other whatever the order of the files, e.g. the
+// scripts of a site replace the stock ones. replace is true if the second one is kept,
+// ok is false if none or both of them are declared with it.
+func keepOverride(first, second map[string]interface{}) (replace, ok bool) {
+	a, b := isOverride(first), isOverride(second)
+	return b, a != b
+}
+
+// duplicateHint tells how to resolve the duplicate ids which keepOverride rejects.
+func duplicateHint(meta map[string]interface{}) string {
+	if isOverride(meta) {
+		return "only one of them may set 'overrides: true'"
+	}
+	return "set 'overrides: true' in the meta description to replace it"
+}
+
+// loadMethod creates the method, the environment variables read by the code at the top
+// level of the script must be allowed by the capabilities of the method.
+func (b *Builder) loadMethod(ctx context.Context, rt *gojs.Runtime, env *envGuard, filename string, pgm *goja.Program,
+	isDefault bool) (string, map[string]interface{}, goja.Callable, error) {
+	env.load()
+	name, meta, method, err := b.createMethod(ctx, rt, filename, pgm, isDefault)
+	if loadErr := env.loaded(name, b.capabilitiesOf(filename, meta)); err == nil && loadErr != nil {
+		err = errors.Wrap(loadErr, filename)
+	}
+	return name, meta, method, err
 }
 
 func (b *Builder) createMethod(ctx context.Context, rt *gojs.Runtime, filename string, pgm *goja.Program,
 	isDefault bool) (string, map[string]interface{}, goja.Callable, error) {
-	gojs.InstantiateEnv(rt)
+	initial := gojs.InstantiateEnv(rt)
 
 	if _, err := rt.RunProgram(ctx, pgm); err != nil {
 		return "", nil, nil, err
 	}
 
-	// Grab exports.
+	// Grab exports, the CommonJS modules such as the transpiled TypeScript replace
+	// module.exports.
 	exportsV := rt.Get("exports")
+	if module, ok := rt.Get("module").(*goja.Object); ok {
+		if v, ok := module.Get("exports").(*goja.Object); ok && v != initial {
+			exportsV = v
+		}
+	}
 	if goja.IsNull(exportsV) || goja.IsUndefined(exportsV) {
 		return "", nil, nil, errors.New("exports must be an object")
 	}
@@ -167,6 +415,13 @@ func (b *Builder) createMethod(ctx context.Context, rt *gojs.Runtime, filename s
 		return "", nil, nil, errors.New("meta description must be a object")
 	}
 
+	// a number such as 1.10 would become "1.1".
+	if version, ok := meta["version"]; ok && version != nil {
+		if _, ok := version.(string); !ok {
+			return "", nil, nil, errors.New("version must be a string in the meta description, e.g. \"1.10\"")
+		}
+	}
+
 	id, ok := meta["id"]
 	if !ok || id == nil {
 		if isDefault {
@@ -23,6 +23,7 @@ package k8
 import (
 	"context"
 	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/dop251/goja"
@@ -391,3 +392,85 @@ func TestBuilderEnv(t *testing.T) {
 		assert.NoError(t, err)
 	}
 }
+
+func TestBuilderConcurrently(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode:    gojs.CompatibilityModeBase.String(),
+		IncludeSystemEnvVars: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// run_script and the pool build the runners concurrently, run it with -race.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := b.BuildString(context.Background(), nil, `exports.default = function() { return "script"; };`)
+			if assert.NoError(t, err) {
+				v, err := r.RunDefaultMethod(context.Background(), goja.Undefined())
+				assert.NoError(t, err)
+				assert.Equal(t, "script", v)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBuilderDuplicateIDs(t *testing.T) {
+	ctx := context.Background()
+	const (
+		stock = `exports.meta = {id: "a"};
+exports.default = function() { return "stock"; };`
+		site = `exports.meta = {id: "a"};
+exports.default = function() { return "site"; };`
+		override = `exports.meta = {id: "a", overrides: true};
+exports.default = function() { return "site"; };`
+	)
+	build := func(first, second [2]string) (*Runner, error) {
+		b, err := NewBuilder(&gojs.RuntimeOptions{
+			CompatibilityMode: gojs.CompatibilityModeBase.String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := b.Compile(first[0], first[1]); err != nil {
+			return nil, err
+		}
+		if err := b.Compile(second[0], second[1]); err != nil {
+			return nil, err
+		}
+		return b.Build(ctx, nil)
+	}
+
+	t.Run("Duplicate", func(t *testing.T) {
+		_, err := build([2]string{"/stock/a.js", stock}, [2]string{"/site/a.js", site})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "duplicate id 'a' in /stock/a.js and /site/a.js")
+		}
+	})
+	t.Run("Overrides", func(t *testing.T) {
+		// the override is kept whatever the order of the files.
+		for _, files := range [][2][2]string{
+			{{"/stock/a.js", stock}, {"/site/a.js", override}},
+			{{"/site/a.js", override}, {"/stock/a.js", stock}},
+		} {
+			r, err := build(files[0], files[1])
+			if !assert.NoError(t, err) {
+				continue
+			}
+			v, err := r.RunMethod(ctx, "a", goja.Undefined())
+			if assert.NoError(t, err) {
+				assert.Equal(t, "site", v, files[0][0]+" is loaded first")
+			}
+		}
+	})
+	t.Run("TwoOverrides", func(t *testing.T) {
+		_, err := build([2]string{"/stock/a.js", override}, [2]string{"/site/a.js", override})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "duplicate id 'a' in /stock/a.js and /site/a.js, only one of them may set 'overrides: true'")
+		}
+	})
+}
@@ -0,0 +1,277 @@
+package k8
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/godoc/vfs"
+	"golang.org/x/tools/godoc/vfs/mapfs"
+)
+
+// ManifestFile is the name of the manifest in the root of a bundle.
+const ManifestFile = "manifest.json"
+
+// A Manifest describes the scripts in a bundle, e.g.
+//
+//	{
+//	  "name": "billing",
+//	  "version": "1.2.0",
+//	  "entries": ["invoice.js", "refund.ts"],
+//	  "config": {"BILLING_CURRENCY": "CNY"},
+//	  "capabilities": ["http"]
+//	}
+type Manifest struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Entries are the scripts to compile, relative to the root of the bundle.
+	Entries []string `json:"entries"`
+	// Config is the default values of the environment variables of the scripts.
+	Config map[string]string `json:"config,omitempty"`
+	// Capabilities are the kinds of the resources required by the scripts in the bundle,
+	// "env", "http" and "fs". The scripts cannot access the kinds which aren't listed,
+	// except the variables in Config and the files of the bundle.
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// A Bundle is an archive of the scripts, it is mounted as a read-only file system.
+type Bundle struct {
+	Manifest Manifest
+	FS       vfs.FileSystem
+}
+
+// MountPoint returns the directory where the bundle is mounted in the namespace.
+func (bundle *Bundle) MountPoint() string {
+	return "/bundles/" + bundle.Manifest.Name
+}
+
+// Entries returns the paths of the entries in the namespace.
+func (bundle *Bundle) Entries() []string {
+	filenames := make([]string, 0, len(bundle.Manifest.Entries))
+	for _, entry := range bundle.Manifest.Entries {
+		filenames = append(filenames, path.Join(bundle.MountPoint(), entry))
+	}
+	return filenames
+}
+
+// limits returns the capabilities of the scripts in the bundle, the kinds which aren't
+// listed in the manifest are restricted to the resources of the bundle.
+func (bundle *Bundle) limits() *Capabilities {
+	limit := &Capabilities{
+		Env:  []string{},
+		HTTP: []string{},
+		FS:   []string{bundle.MountPoint()},
+	}
+	for name := range bundle.Manifest.Config {
+		limit.Env = append(limit.Env, name)
+	}
+	sort.Strings(limit.Env)
+	for _, kind := range bundle.Manifest.Capabilities {
+		switch kind {
+		case "env":
+			limit.Env = nil
+		case "http":
+			limit.HTTP = nil
+		case "fs":
+			limit.FS = nil
+		}
+	}
+	return limit
+}
+
+// IsBundle returns true if the file is an archive supported by ReadBundle.
+func IsBundle(filename string) bool {
+	filename = strings.ToLower(filename)
+	return strings.HasSuffix(filename, ".zip") ||
+		strings.HasSuffix(filename, ".tar.gz") ||
+		strings.HasSuffix(filename, ".tgz")
+}
+
+// ReadBundle reads a zip or tar.gz archive, the format is detected by the name.
+func ReadBundle(filename string, data []byte) (*Bundle, error) {
+	var files map[string]string
+	var err error
+	if strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		files, err = readZip(data)
+	} else {
+		files, err = readTarGz(data)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, filename)
+	}
+
+	text, ok := files[ManifestFile]
+	if !ok {
+		return nil, errors.New(filename + ": " + ManifestFile + " is missing")
+	}
+	var manifest Manifest
+	if err := json.Unmarshal([]byte(text), &manifest); err != nil {
+		return nil, errors.Wrap(err, filename+": invalid "+ManifestFile)
+	}
+	if manifest.Name == "" {
+		name := path.Base(filename)
+		for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+			if strings.HasSuffix(strings.ToLower(name), ext) {
+				name = name[:len(name)-len(ext)]
+				break
+			}
+		}
+		manifest.Name = name
+	}
+	if strings.ContainsAny(manifest.Name, "/\\") || manifest.Name == "." || manifest.Name == ".." {
+		return nil, errors.New(filename + ": invalid name '" + manifest.Name + "' in " + ManifestFile)
+	}
+	if len(manifest.Entries) == 0 {
+		return nil, errors.New(filename + ": entries are missing in " + ManifestFile)
+	}
+	for _, kind := range manifest.Capabilities {
+		if kind != "env" && kind != "http" && kind != "fs" {
+			return nil, errors.New(filename + ": unknown capability '" + kind + "' in " + ManifestFile +
+				", it must be one of env, http and fs")
+		}
+	}
+	for i, entry := range manifest.Entries {
+		name, err := cleanBundlePath(entry)
+		if err != nil {
+			return nil, errors.Wrap(err, filename)
+		}
+		if _, ok := files[name]; !ok {
+			return nil, errors.New(filename + ": entry '" + entry + "' is not found")
+		}
+		manifest.Entries[i] = name
+	}
+
+	return &Bundle{
+		Manifest: manifest,
+		FS:       mapfs.New(files),
+	}, nil
+}
+
+// cleanBundlePath returns the path relative to the root of the bundle, the paths outside
+// the bundle are rejected.
+func cleanBundlePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", errors.New("invalid path '" + name + "'")
+		}
+	}
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if cleaned == "" {
+		return "", errors.New("invalid path '" + name + "'")
+	}
+	return cleaned, nil
+}
+
+func readZip(data []byte) (map[string]string, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := cleanBundlePath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, f.Name)
+		}
+		files[name] = string(content)
+	}
+	return files, nil
+}
+
+func readTarGz(data []byte) (map[string]string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := map[string]string{}
+	r := tar.NewReader(gz)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		name, err := cleanBundlePath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, hdr.Name)
+		}
+		files[name] = string(content)
+	}
+}
+
+// mountBundles reads the bundles in the filenames and mounts them into a copy of the
+// namespace, the filenames of the bundles are replaced with their entries. The signatures
+// of the bundles are checked if verifier isn't nil.
+func mountBundles(fs vfs.NameSpace, filenames []string, verifier *Verifier) (vfs.NameSpace, []string, []*Bundle, error) {
+	var bundles []*Bundle
+	ns := fs
+	results := make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		if !IsBundle(filename) {
+			results = append(results, filename)
+			continue
+		}
+
+		data, err := vfs.ReadFile(fs, filename)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if verifier != nil {
+			if err := verifier.Verify(fs, filename, data); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		bundle, err := ReadBundle(filename, data)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, other := range bundles {
+			if other.Manifest.Name == bundle.Manifest.Name {
+				return nil, nil, nil, errors.New("duplicate bundle '" + bundle.Manifest.Name + "'")
+			}
+		}
+		if len(bundles) == 0 {
+			// the namespace of the caller is never changed.
+			ns = vfs.NameSpace{}
+			for k, v := range fs {
+				ns[k] = v
+			}
+		}
+		ns.Bind(bundle.MountPoint(), bundle.FS, "/", vfs.BindReplace)
+		bundles = append(bundles, bundle)
+		results = append(results, bundle.Entries()...)
+	}
+	return ns, results, bundles, nil
+}
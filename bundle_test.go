@@ -0,0 +1,197 @@
+package k8
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/godoc/vfs"
+	"golang.org/x/tools/godoc/vfs/mapfs"
+)
+
+func zipBundle(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func tarGzBundle(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, w.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestReadBundle(t *testing.T) {
+	files := map[string]string{
+		"manifest.json": `{"name": "billing", "entries": ["scripts/invoice.js"], "config": {"CURRENCY": "CNY"}}`,
+		"scripts/invoice.js": `exports.meta = {id: "invoice"};
+exports.default = function() { return __ENV.CURRENCY; };`,
+	}
+
+	for name, data := range map[string][]byte{
+		"billing.zip":    zipBundle(t, files),
+		"billing.tar.gz": tarGzBundle(t, files),
+	} {
+		bundle, err := ReadBundle(name, data)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		assert.Equal(t, "billing", bundle.Manifest.Name)
+		assert.Equal(t, []string{"/bundles/billing/scripts/invoice.js"}, bundle.Entries())
+		assert.Equal(t, map[string]string{"CURRENCY": "CNY"}, bundle.Manifest.Config)
+
+		content, err := vfs.ReadFile(bundle.FS, "/scripts/invoice.js")
+		if assert.NoError(t, err) {
+			assert.Equal(t, files["scripts/invoice.js"], string(content))
+		}
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, files := range map[string]map[string]string{
+			"manifest.json is missing":  {"a.js": ""},
+			"entries are missing":       {"manifest.json": `{"name": "a"}`},
+			"entry 'b.js' is not found": {"manifest.json": `{"entries": ["b.js"]}`, "a.js": ""},
+			"invalid path '../a.js'":    {"manifest.json": `{"entries": ["a.js"]}`, "../a.js": ""},
+			"unknown capability 'db'":   {"manifest.json": `{"entries": ["a.js"], "capabilities": ["db"]}`, "a.js": ""},
+		} {
+			_, err := ReadBundle("a.zip", zipBundle(t, files))
+			if assert.Error(t, err, name) {
+				assert.Contains(t, err.Error(), name)
+			}
+		}
+	})
+}
+
+func TestMountBundles(t *testing.T) {
+	ns := vfs.NameSpace{}
+	ns.Bind("/", mapfs.New(map[string]string{
+		"scripts/a.js": `exports.meta = {id: "a"};
+exports.default = function() { return "a"; };`,
+		"bundles/billing.zip": string(zipBundle(t, map[string]string{
+			"manifest.json": `{"name": "billing", "entries": ["invoice.js"]}`,
+			"invoice.js": `exports.meta = {id: "invoice"};
+exports.default = function() { return "invoice"; };`,
+		})),
+	}), "/", vfs.BindReplace)
+
+	mounted, filenames, bundles, err := mountBundles(ns, []string{"/scripts/a.js", "/bundles/billing.zip"}, nil)
+	require.NoError(t, err)
+	require.Len(t, bundles, 1)
+	assert.Equal(t, []string{"/scripts/a.js", "/bundles/billing/invoice.js"}, filenames)
+
+	// the namespace of the caller isn't changed.
+	_, err = vfs.ReadFile(ns, "/bundles/billing/invoice.js")
+	assert.Error(t, err)
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	for _, filename := range filenames {
+		data, err := vfs.ReadFile(mounted, filename)
+		require.NoError(t, err)
+		require.NoError(t, b.Compile(filename, string(data)))
+	}
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+	v, err := r.RunMethod(context.Background(), "invoice", goja.Undefined())
+	require.NoError(t, err)
+	assert.Equal(t, "invoice", v)
+}
+
+func TestBundleCapabilities(t *testing.T) {
+	build := func(capabilities string) *Runner {
+		bundle, err := ReadBundle("billing.zip", zipBundle(t, map[string]string{
+			"manifest.json": `{"name": "billing", "entries": ["invoice.js"], "config": {"CURRENCY": "CNY"}` +
+				capabilities + `}`,
+			"invoice.js": `exports.meta = {id: "invoice"};
+exports.default = function(name) { return __ENV[name]; };`,
+		}))
+		require.NoError(t, err)
+
+		b, err := NewBuilder(&gojs.RuntimeOptions{
+			CompatibilityMode: gojs.CompatibilityModeBase.String(),
+			Env:               map[string]string{"CURRENCY": "CNY", "TOKEN": "secret"},
+		})
+		require.NoError(t, err)
+		for _, entry := range bundle.Entries() {
+			data, err := vfs.ReadFile(bundle.FS, strings.TrimPrefix(entry, bundle.MountPoint()))
+			require.NoError(t, err)
+			require.NoError(t, b.Compile(entry, string(data)))
+			b.limitCapabilities(entry, bundle.limits())
+		}
+		r, err := b.Build(context.Background(), nil)
+		require.NoError(t, err)
+		return r
+	}
+
+	// the scripts only see the config of the bundle unless "env" is required.
+	r := build("")
+	v, err := r.RunMethod(context.Background(), "invoice", "CURRENCY")
+	require.NoError(t, err)
+	assert.Equal(t, "CNY", v)
+	_, err = r.RunMethod(context.Background(), "invoice", "TOKEN")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission denied: method 'invoice' cannot access env 'TOKEN'")
+	}
+
+	r = build(`, "capabilities": ["env"]`)
+	v, err = r.RunMethod(context.Background(), "invoice", "TOKEN")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", v)
+}
+
+func TestDeploymentsReload(t *testing.T) {
+	ctx := context.Background()
+	b, err := getSimpleBuilder("/script.js", `exports.default = function() { return 1; };`)
+	require.NoError(t, err)
+	d, err := newDeployment(ctx, b, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, d.pool.Size())
+
+	current := &deployments{}
+	current.set(d)
+
+	_, err = current.reload(func() (*deployment, error) {
+		return nil, errors.New("broken")
+	})
+	assert.Error(t, err)
+	assert.Same(t, d, current.get())
+
+	b2, err := getSimpleBuilder("/script.js", `exports.default = function() { return 2; };`)
+	require.NoError(t, err)
+	d2, err := current.reload(func() (*deployment, error) {
+		return newDeployment(ctx, b2, 2)
+	})
+	require.NoError(t, err)
+	assert.Same(t, d2, current.get())
+}
@@ -0,0 +1,294 @@
+package k8
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// The modes of a Cassette.
+const (
+	// CassetteRecord sends the requests and records them with the responses.
+	CassetteRecord = "record"
+	// CassetteReplay answers the requests with the recorded responses, a request which
+	// isn't recorded fails.
+	CassetteReplay = "replay"
+)
+
+// DefaultCassetteRedactHeaders are the headers of the requests and the responses which
+// aren't written into the cassette by default.
+//
+//nolint:gochecknoglobals
+var DefaultCassetteRedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// DefaultCassetteRedactQuery are the query parameters which are redacted in the recorded
+// urls by default.
+//
+//nolint:gochecknoglobals
+var DefaultCassetteRedactQuery = []string{"access_token", "api_key", "apikey", "password", "secret", "token"}
+
+// CassetteRequest is a recorded request.
+type CassetteRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+	// Base64 is true if the body is binary and encoded in base64.
+	Base64 bool `json:"base64,omitempty"`
+}
+
+// CassetteResponse is a recorded response.
+type CassetteResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+	Base64  bool        `json:"base64,omitempty"`
+}
+
+// An Interaction is a request with its response.
+type Interaction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// A Cassette records the http requests made by the scripts and replays them later, so
+// the scripts can be tested without the services they call. It is set to Runner.Cassette,
+// the recorded requests are saved after every invocation.
+//
+// In the replay mode, a request is answered by the first interaction which isn't replayed
+// yet and has the same method, url and body.
+type Cassette struct {
+	Filename string
+	Mode     string
+	// RedactHeaders are removed from the recorded requests and responses, RedactQuery are
+	// the query parameters whose values are replaced in the recorded urls, the names are
+	// case insensitive. NewCassette sets them to the defaults.
+	RedactHeaders []string
+	RedactQuery   []string
+
+	mu           sync.Mutex
+	interactions []Interaction
+	replayed     []bool
+}
+
+// NewCassette creates a cassette, the interactions are loaded from the file in the replay
+// mode.
+func NewCassette(filename, mode string) (*Cassette, error) {
+	cassette := &Cassette{
+		Filename:      filename,
+		Mode:          mode,
+		RedactHeaders: append([]string(nil), DefaultCassetteRedactHeaders...),
+		RedactQuery:   append([]string(nil), DefaultCassetteRedactQuery...),
+	}
+	switch mode {
+	case CassetteRecord:
+		return cassette, nil
+	case CassetteReplay:
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var content struct {
+			Interactions []Interaction `json:"interactions"`
+		}
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, errors.Wrap(err, "invalid cassette '"+filename+"'")
+		}
+		cassette.interactions = content.Interactions
+		cassette.replayed = make([]bool, len(content.Interactions))
+		return cassette, nil
+	default:
+		return nil, errors.New("invalid mode '" + mode + "' of the cassette, it must be 'record' or 'replay'")
+	}
+}
+
+// Interactions returns the recorded interactions.
+func (cassette *Cassette) Interactions() []Interaction {
+	cassette.mu.Lock()
+	defer cassette.mu.Unlock()
+	return append([]Interaction(nil), cassette.interactions...)
+}
+
+// Rewind makes all interactions can be replayed again.
+func (cassette *Cassette) Rewind() {
+	cassette.mu.Lock()
+	defer cassette.mu.Unlock()
+	cassette.replayed = make([]bool, len(cassette.interactions))
+}
+
+// Save writes the interactions into the file, it does nothing in the replay mode.
+func (cassette *Cassette) Save() error {
+	if cassette.Mode != CassetteRecord {
+		return nil
+	}
+	cassette.mu.Lock()
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"interactions": cassette.interactions,
+	}, "", "  ")
+	cassette.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := cassette.Filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0o644); err != nil { //nolint:gosec
+		return err
+	}
+	return os.Rename(tmp, cassette.Filename)
+}
+
+// redactURL returns the url in which the values of the query parameters in RedactQuery
+// are replaced, the requests are matched by it in the replay mode.
+func (cassette *Cassette) redactURL(u *url.URL) string {
+	if len(cassette.RedactQuery) == 0 || u.RawQuery == "" {
+		return u.String()
+	}
+	query := u.Query()
+	redacted := false
+	for name, values := range query {
+		for _, field := range cassette.RedactQuery {
+			if strings.EqualFold(name, field) {
+				for i := range values {
+					values[i] = redactedValue
+				}
+				redacted = true
+				break
+			}
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	copied := *u
+	copied.RawQuery = query.Encode()
+	return copied.String()
+}
+
+func encodeBody(data []byte) (string, bool) {
+	if utf8.Valid(data) {
+		return string(data), false
+	}
+	return base64.StdEncoding.EncodeToString(data), true
+}
+
+func decodeBody(body string, isBase64 bool) ([]byte, error) {
+	if isBase64 {
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return []byte(body), nil
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// cassetteTransport records or replays the requests.
+type cassetteTransport struct {
+	base     http.RoundTripper
+	cassette *Cassette
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.cassette.Mode == CassetteReplay {
+		return t.cassette.replay(req, body)
+	}
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	interaction := Interaction{
+		Request: CassetteRequest{
+			Method:  req.Method,
+			URL:     t.cassette.redactURL(req.URL),
+			Headers: req.Header.Clone(),
+		},
+		Response: CassetteResponse{
+			Status:  resp.StatusCode,
+			Headers: resp.Header.Clone(),
+		},
+	}
+	for _, name := range t.cassette.RedactHeaders {
+		interaction.Request.Headers.Del(name)
+		interaction.Response.Headers.Del(name)
+	}
+	interaction.Request.Body, interaction.Request.Base64 = encodeBody(body)
+	interaction.Response.Body, interaction.Response.Base64 = encodeBody(respBody)
+
+	t.cassette.mu.Lock()
+	t.cassette.interactions = append(t.cassette.interactions, interaction)
+	t.cassette.mu.Unlock()
+	return resp, nil
+}
+
+func (cassette *Cassette) replay(req *http.Request, body []byte) (*http.Response, error) {
+	cassette.mu.Lock()
+	defer cassette.mu.Unlock()
+
+	url := cassette.redactURL(req.URL)
+	for i, interaction := range cassette.interactions {
+		if cassette.replayed[i] || interaction.Request.Method != req.Method || interaction.Request.URL != url {
+			continue
+		}
+		recorded, err := decodeBody(interaction.Request.Body, interaction.Request.Base64)
+		if err != nil || !bytes.Equal(recorded, body) {
+			continue
+		}
+		respBody, err := decodeBody(interaction.Response.Body, interaction.Response.Base64)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid body of the response of '"+req.Method+" "+url+"' in the cassette")
+		}
+		cassette.replayed[i] = true
+
+		header := interaction.Response.Headers.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        strconv.Itoa(interaction.Response.Status) + " " + http.StatusText(interaction.Response.Status),
+			StatusCode:    interaction.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+			ContentLength: int64(len(respBody)),
+			Request:       req,
+		}, nil
+	}
+	return nil, errors.New("unexpected request '" + req.Method + " " + url + "', it isn't in the cassette '" + cassette.Filename + "'")
+}
@@ -0,0 +1,127 @@
+package k8
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCassette(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + r.URL.Query().Get("name")))
+	}))
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.SetMetrics(NewMetrics())
+	b.RegisterModule("fetch", ModuleFunc(fetchModule))
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function(name) { return k8.fetch(__ENV.URL + "/?name=" + name); };`))
+
+	filename := filepath.Join(t.TempDir(), "a.json")
+	run := func(mode string, names ...string) ([]interface{}, error) {
+		r, err := b.Build(context.Background(), nil)
+		require.NoError(t, err)
+		r.Runtime.Set("__ENV", map[string]string{"URL": srv.URL})
+		r.Cassette, err = NewCassette(filename, mode)
+		require.NoError(t, err)
+
+		var results []interface{}
+		for _, name := range names {
+			v, err := r.RunMethod(context.Background(), "a", name)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, v)
+		}
+		return results, nil
+	}
+
+	results, err := run(CassetteRecord, "a", "b")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"hello a", "hello b"}, results)
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"body": "hello b"`)
+
+	// the services are not needed to replay.
+	srv.Close()
+	results, err = run(CassetteReplay, "b", "a")
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"hello b", "hello a"}, results)
+
+	_, err = run(CassetteReplay, "a", "a")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unexpected request 'GET "+srv.URL+"/?name=a', it isn't in the cassette")
+	}
+
+	_, err = NewCassette(filename, "play")
+	assert.Error(t, err)
+}
+
+func TestCassetteRedact(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret-cookie"})
+		_, _ = w.Write([]byte("hello " + r.URL.Query().Get("name")))
+	}))
+	defer srv.Close()
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.SetMetrics(NewMetrics())
+	b.RegisterModule("fetch", ModuleFunc(fetchModule))
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function(name) { return k8.fetch(__ENV.URL + "/?name=" + name + "&Token=s3cret-token"); };`))
+
+	run := func(cassette *Cassette) string {
+		r, err := b.Build(context.Background(), nil)
+		require.NoError(t, err)
+		r.Runtime.Set("__ENV", map[string]string{"URL": srv.URL})
+		r.Cassette = cassette
+		v, err := r.RunMethod(context.Background(), "a", "a")
+		require.NoError(t, err)
+		assert.Equal(t, "hello a", v)
+		data, err := ioutil.ReadFile(cassette.Filename)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	filename := filepath.Join(t.TempDir(), "a.json")
+	cassette, err := NewCassette(filename, CassetteRecord)
+	require.NoError(t, err)
+	data := run(cassette)
+	assert.NotContains(t, data, "s3cret-token")
+	assert.NotContains(t, data, "s3cret-cookie")
+	assert.Contains(t, data, "name=a")
+
+	// the redacted requests are still replayed.
+	replay, err := NewCassette(filename, CassetteReplay)
+	require.NoError(t, err)
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+	r.Runtime.Set("__ENV", map[string]string{"URL": srv.URL})
+	r.Cassette = replay
+	v, err := r.RunMethod(context.Background(), "a", "a")
+	require.NoError(t, err)
+	assert.Equal(t, "hello a", v)
+
+	cassette, err = NewCassette(filepath.Join(t.TempDir(), "b.json"), CassetteRecord)
+	require.NoError(t, err)
+	cassette.RedactHeaders = nil
+	cassette.RedactQuery = []string{"name"}
+	data = run(cassette)
+	assert.Contains(t, data, "s3cret-token")
+	assert.Contains(t, data, "s3cret-cookie")
+	assert.NotContains(t, data, "name=a")
+}
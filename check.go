@@ -0,0 +1,386 @@
+package k8
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//nolint:gochecknoglobals
+var (
+	// knownMetaKeys are the keys allowed in the meta description.
+	knownMetaKeys = map[string]bool{
+		"id":          true,
+		"name":        true,
+		"description": true,
+		"params":      true,
+		"redact":      true,
+		"overrides":   true,
+		"version":     true,
+		"deprecated":  true,
+		"env":         true,
+		"http":        true,
+		"fs":          true,
+		"kv":          true,
+		"log":         true,
+	}
+
+	// knownParamKeys are the keys allowed in the description of a parameter.
+	knownParamKeys = map[string]bool{
+		"type":        true,
+		"required":    true,
+		"description": true,
+		"default":     true,
+		"enum":        true,
+	}
+
+	knownParamTypes = map[string]bool{
+		"string":  true,
+		"number":  true,
+		"integer": true,
+		"boolean": true,
+		"object":  true,
+		"array":   true,
+	}
+)
+
+// A Problem is found in a script by Check, Line is 0 if it is unknown.
+type Problem struct {
+	Filename string `json:"filename"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return p.Filename + ":" + strconv.Itoa(p.Line) + ": " + p.Message
+	}
+	return p.Filename + ": " + p.Message
+}
+
+// CheckError contains all problems found in the scripts.
+type CheckError struct {
+	Problems []Problem
+}
+
+func (e *CheckError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(len(e.Problems)))
+	if len(e.Problems) == 1 {
+		sb.WriteString(" problem found in the scripts:")
+	} else {
+		sb.WriteString(" problems found in the scripts:")
+	}
+	for _, p := range e.Problems {
+		sb.WriteString("\n\t")
+		sb.WriteString(p.String())
+	}
+	return sb.String()
+}
+
+func newCheckError(problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Filename != problems[j].Filename {
+			return problems[i].Filename < problems[j].Filename
+		}
+		return problems[i].Line < problems[j].Line
+	})
+	return &CheckError{Problems: problems}
+}
+
+// syntaxErrorPattern matches the position in the syntax errors of goja, such as
+// "/a.js: Line 3:42 Unexpected token".
+//
+//nolint:gochecknoglobals
+var syntaxErrorPattern = regexp.MustCompile(`([^\s()]+): Line (\d+):(\d+)`)
+
+// problemOf converts the error of the script to a problem, the position is taken from
+// the message of the error.
+func problemOf(filename string, err error) Problem {
+	msg := err.Error()
+	parts := positionPattern.FindStringSubmatch(msg)
+	if parts == nil {
+		parts = syntaxErrorPattern.FindStringSubmatch(msg)
+	}
+	if parts != nil {
+		line, _ := strconv.Atoi(parts[2])
+		return Problem{Filename: parts[1], Line: line, Message: msg}
+	}
+	return Problem{Filename: filename, Message: msg}
+}
+
+// Check runs the initialisation of all compiled scripts in a throwaway runtime and
+// validates their meta descriptions. It returns a *CheckError with every problem found,
+// including the errors of the scripts which failed to compile, or nil if there is no
+// problem.
+func (b *Builder) Check(ctx context.Context) error {
+	return b.check(ctx, len(b.methodPrograms()) == 1)
+}
+
+// check is same as Check, the meta description is optional if isDefault is true.
+func (b *Builder) check(ctx context.Context, isDefault bool) error {
+	rt, err := b.newRuntime()
+	if err != nil {
+		return err
+	}
+	installConsole(rt)
+	installModules(rt, b.modules)
+
+	problems := append([]Problem(nil), b.failures...)
+	ids := map[string]string{}
+	metas := map[string]map[string]interface{}{}
+	for _, pgm := range b.methodPrograms() {
+		name, meta, _, err := b.createMethod(ctx, rt, pgm.Filename, pgm.Program, isDefault)
+		if err != nil {
+			err = b.sourceMaps.rewrite(err)
+			p := problemOf(pgm.Filename, err)
+			if p.Line == 0 && strings.Contains(p.Message, "meta description") {
+				p.Line = metaLine(pgm.Source)
+			}
+			problems = append(problems, p)
+			continue
+		}
+
+		for _, msg := range validateMeta(meta) {
+			problems = append(problems, Problem{
+				Filename: pgm.Filename,
+				Line:     metaKeyLine(pgm.Source, msg.key),
+				Message:  msg.text,
+			})
+		}
+
+		if name == "" {
+			continue
+		}
+		key := methodKey(name, meta)
+		if other, ok := ids[key]; ok {
+			replace, ok := keepOverride(metas[key], meta)
+			if !ok {
+				msg := "duplicate id '" + key + "', it is also defined in " + other
+				if isOverride(meta) {
+					msg += ", " + duplicateHint(meta)
+				}
+				problems = append(problems, Problem{
+					Filename: pgm.Filename,
+					Line:     metaKeyLine(pgm.Source, "id"),
+					Message:  msg,
+				})
+				continue
+			}
+			if !replace {
+				continue
+			}
+		}
+		ids[key] = pgm.Filename
+		metas[key] = meta
+	}
+	return newCheckError(problems)
+}
+
+// metaWarnings returns the problems of the meta descriptions of the methods built in the
+// runner, they are errors only for Check, the scripts still run in service.
+func metaWarnings(r *Runner) []string {
+	keys := make([]string, 0, len(r.Methods))
+	for key := range r.Methods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var warnings []string
+	seen := map[uintptr]bool{}
+	for _, key := range keys {
+		meta := r.Methods[key].Meta
+		// the default version is also registered without the version.
+		if p := reflect.ValueOf(meta).Pointer(); seen[p] {
+			continue
+		} else {
+			seen[p] = true
+		}
+		for _, msg := range validateMeta(meta) {
+			warnings = append(warnings, "method '"+key+"': "+msg.text)
+		}
+	}
+	return warnings
+}
+
+type metaProblem struct {
+	key  string
+	text string
+}
+
+func isStringList(v interface{}) bool {
+	list, ok := v.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func validateMeta(meta map[string]interface{}) []metaProblem {
+	var problems []metaProblem
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !knownMetaKeys[key] {
+			problems = append(problems, metaProblem{key, "unknown key '" + key + "' in the meta description"})
+		}
+	}
+	if id, ok := meta["id"]; ok {
+		switch v := id.(type) {
+		case string:
+			if v == "" {
+				problems = append(problems, metaProblem{"id", "id must be a non-empty string or a number"})
+			}
+		case int64, float64:
+		default:
+			problems = append(problems, metaProblem{"id", "id must be a non-empty string or a number"})
+		}
+	}
+	if version, ok := meta["version"]; ok {
+		switch v := version.(type) {
+		case string:
+			if v == "" || strings.ContainsAny(v, "@/ ") {
+				problems = append(problems, metaProblem{"version", "version must be a non-empty string without '@', '/' and spaces"})
+			}
+		default:
+			problems = append(problems, metaProblem{"version", "version must be a string, e.g. \"1.10\""})
+		}
+	}
+	if deprecated, ok := meta["deprecated"]; ok {
+		if _, ok := deprecated.(bool); !ok {
+			problems = append(problems, metaProblem{"deprecated", "deprecated must be a boolean"})
+		}
+	}
+	if overrides, ok := meta["overrides"]; ok {
+		if _, ok := overrides.(bool); !ok {
+			problems = append(problems, metaProblem{"overrides", "overrides must be a boolean"})
+		}
+	}
+	if kv, ok := meta["kv"]; ok {
+		if s, ok := kv.(string); !ok || s == "" {
+			problems = append(problems, metaProblem{"kv", "kv must be a non-empty string"})
+		}
+	}
+	if level, ok := meta["log"]; ok {
+		s, _ := level.(string)
+		if _, ok := logLevels[strings.ToLower(s)]; !ok {
+			problems = append(problems, metaProblem{"log", "log must be one of debug, info, warn, error and off"})
+		}
+	}
+	for _, key := range []string{"env", "http", "fs"} {
+		if list, ok := meta[key]; ok && !isStringList(list) {
+			problems = append(problems, metaProblem{key, key + " must be an array of strings"})
+		}
+	}
+	if params, ok := meta["params"]; ok {
+		problems = append(problems, validateParams(params)...)
+	}
+	return problems
+}
+
+// validateParams checks the description of the parameters, e.g.
+//
+//	params: {
+//	  name:  {type: "string", required: true, description: "the name of the user"},
+//	  limit: {type: "integer", default: 10}
+//	}
+func validateParams(params interface{}) []metaProblem {
+	fields, ok := params.(map[string]interface{})
+	if !ok {
+		return []metaProblem{{"params", "params must be an object"}}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []metaProblem
+	for _, name := range names {
+		param, ok := fields[name].(map[string]interface{})
+		if !ok {
+			problems = append(problems, metaProblem{name, "param '" + name + "' must be an object"})
+			continue
+		}
+
+		keys := make([]string, 0, len(param))
+		for key := range param {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !knownParamKeys[key] {
+				problems = append(problems, metaProblem{name, "unknown key '" + key + "' in param '" + name + "'"})
+			}
+		}
+
+		if typ, ok := param["type"]; ok {
+			if s, ok := typ.(string); !ok || !knownParamTypes[s] {
+				problems = append(problems, metaProblem{name, fmt.Sprintf("invalid type '%v' of param '%s'", typ, name)})
+			}
+		}
+		if required, ok := param["required"]; ok {
+			if _, ok := required.(bool); !ok {
+				problems = append(problems, metaProblem{name, "required of param '" + name + "' must be a boolean"})
+			}
+		}
+		if enum, ok := param["enum"]; ok {
+			if _, ok := enum.([]interface{}); !ok {
+				problems = append(problems, metaProblem{name, "enum of param '" + name + "' must be an array"})
+			}
+		}
+	}
+	return problems
+}
+
+//nolint:gochecknoglobals
+var metaPattern = regexp.MustCompile(`\bmeta\s*[=:]`)
+
+// metaLine returns the line where the meta description is defined, it is 0 if it is not
+// found.
+func metaLine(source string) int {
+	loc := metaPattern.FindStringIndex(source)
+	if loc == nil {
+		return 0
+	}
+	return strings.Count(source[:loc[0]], "\n") + 1
+}
+
+// metaKeyLine returns the line of the key in the meta description, or the line of the
+// meta description if the key is not found.
+func metaKeyLine(source, key string) int {
+	loc := metaPattern.FindStringIndex(source)
+	if loc == nil {
+		return 0
+	}
+	keyPattern, err := regexp.Compile(`(?:^|[{,\s])["']?` + regexp.QuoteMeta(key) + `["']?\s*:`)
+	if err != nil {
+		return metaLine(source)
+	}
+	keyLoc := keyPattern.FindStringIndex(source[loc[1]:])
+	if keyLoc == nil {
+		return metaLine(source)
+	}
+	offset := loc[1] + keyLoc[0]
+	// skip the separator matched before the key.
+	if c := source[offset]; c == '{' || c == ',' || c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+		offset++
+	}
+	return strings.Count(source[:offset], "\n") + 1
+}
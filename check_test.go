@@ -0,0 +1,141 @@
+package k8
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+
+	scripts := []struct {
+		filename string
+		code     string
+	}{
+		{"/a.js", `exports.meta = {
+	id: "a",
+	params: {
+		name: {type: "string", required: true},
+		limit: {type: "int"}
+	}
+};
+exports.default = function() {};`},
+		{"/b.js", `exports.meta = {
+	id: "a",
+	unknown: 1
+};
+exports.default = function() {};`},
+		{"/c.js", `exports.meta = {name: "c"};
+exports.default = function() {};`},
+		{"/d.js", `
+throw new Error("init failed");`},
+	}
+	for _, script := range scripts {
+		require.NoError(t, b.Compile(script.filename, script.code))
+	}
+
+	err = b.Check(context.Background())
+	require.Error(t, err)
+	checkErr, ok := err.(*CheckError)
+	require.True(t, ok)
+
+	var problems []string
+	for _, p := range checkErr.Problems {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, []string{
+		"/a.js:5: invalid type 'int' of param 'limit'",
+		"/b.js:2: duplicate id 'a', it is also defined in /a.js",
+		"/b.js:3: unknown key 'unknown' in the meta description",
+		"/c.js:1: id is missing in the meta description",
+		"/d.js:2: Error: init failed at /d.js:2:7(3)",
+	}, problems)
+}
+
+func TestCheckSingleScript(t *testing.T) {
+	b, err := getSimpleBuilder("/script.js", `exports.default = function() {};`)
+	require.NoError(t, err)
+	assert.NoError(t, b.Check(context.Background()))
+}
+
+func TestCheckNumericID(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: 1};
+exports.default = function() {};`))
+	require.NoError(t, b.Compile("/b.js", `exports.meta = {id: "b", unknown: 1};
+exports.default = function() {};`))
+
+	err = b.Check(context.Background())
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "/a.js")
+	}
+
+	// the scripts still run in service, the problems are only warnings.
+	d, err := newDeployment(context.Background(), b, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"method 'b': unknown key 'unknown' in the meta description"}, d.warnings)
+}
+
+func TestCheckSyntaxErrors(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	assert.Error(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() {;`))
+	assert.Error(t, b.Compile("/b.js", `exports.meta = {id: "b"};
+
+exports.default = function() { return 1 +; };`))
+	require.NoError(t, b.Compile("/c.js", `exports.meta = {id: "c", unknown: 1};
+exports.default = function() {};`))
+
+	err = b.Check(context.Background())
+	require.Error(t, err)
+	checkErr, ok := err.(*CheckError)
+	require.True(t, ok)
+
+	var files []string
+	for _, p := range checkErr.Problems {
+		files = append(files, p.Filename)
+	}
+	assert.Equal(t, []string{"/a.js", "/b.js", "/c.js"}, files)
+	assert.Equal(t, 3, checkErr.Problems[1].Line, checkErr.Problems[1].Message)
+	assert.Equal(t, "/c.js:1: unknown key 'unknown' in the meta description", checkErr.Problems[2].String())
+}
+
+func TestCheckOverrides(t *testing.T) {
+	check := func(scripts ...[2]string) error {
+		b, err := NewBuilder(&gojs.RuntimeOptions{
+			CompatibilityMode: gojs.CompatibilityModeBase.String(),
+		})
+		require.NoError(t, err)
+		for _, script := range scripts {
+			require.NoError(t, b.Compile(script[0], script[1]))
+		}
+		return b.Check(context.Background())
+	}
+	stock := [2]string{"/stock/a.js", `exports.meta = {id: "a"};
+exports.default = function() {};`}
+	site := [2]string{"/site/a.js", `exports.meta = {id: "a", overrides: true};
+exports.default = function() {};`}
+
+	assert.NoError(t, check(stock, site))
+	assert.NoError(t, check(site, stock))
+
+	other := [2]string{"/other/a.js", site[1]}
+	err := check(site, other)
+	if assert.Error(t, err) {
+		assert.Equal(t, "/other/a.js:1: duplicate id 'a', it is also defined in /site/a.js, only one of them may set 'overrides: true'",
+			err.(*CheckError).Problems[0].String())
+	}
+}
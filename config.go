@@ -0,0 +1,108 @@
+package k8
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/runner-mei/log"
+)
+
+// A ConfigSource returns the values of the application config, it is env.Config in the
+// application. An empty value means the key isn't set.
+type ConfigSource interface {
+	StringWithDefault(key, value string) string
+}
+
+// ConfigModule exposes the keys under the prefix of the config as k8.config, the prefix
+// is omitted in the scripts, e.g. with the prefix "k8.scripts.":
+//
+//	var url = k8.config.getString("crm.url", "http://127.0.0.1");      // k8.scripts.crm.url
+//	var limit = k8.config.getInt("crm.limit", 100);
+//	var ratio = k8.config.getFloat("crm.ratio", 0.5);
+//	var enabled = k8.config.getBool("crm.enabled", false);
+//	var timeout = k8.config.getDuration("crm.timeout", 3000); // milliseconds, e.g. "3s"
+//
+// The values are read on every call, so the scripts see the changes of the config at once.
+// The invalid values are logged and the defaults are returned.
+func ConfigModule(src ConfigSource, prefix string) Module {
+	return ModuleFunc(func(rt *gojs.Runtime) interface{} {
+		get := func(call goja.FunctionCall) (string, string) {
+			name := call.Argument(0).String()
+			if goja.IsUndefined(call.Argument(0)) || name == "" {
+				panic(rt.NewTypeError("k8.config: key is missing"))
+			}
+			key := prefix + name
+			return key, src.StringWithDefault(key, "")
+		}
+		invalid := func(ctx context.Context, key, typ string, call goja.FunctionCall) goja.Value {
+			if state := lib.GetState(ctx); state != nil && state.Logger != nil {
+				state.Logger.Warn("invalid "+typ+" in the config '"+key+"', use the default value",
+					log.String("key", key))
+			}
+			return call.Argument(1)
+		}
+
+		return map[string]interface{}{
+			"has": func(call goja.FunctionCall) goja.Value {
+				_, s := get(call)
+				return rt.ToValue(s != "")
+			},
+			"getString": func(call goja.FunctionCall) goja.Value {
+				_, s := get(call)
+				if s == "" {
+					return call.Argument(1)
+				}
+				return rt.ToValue(s)
+			},
+			"getInt": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				key, s := get(call)
+				if s == "" {
+					return call.Argument(1)
+				}
+				i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+				if err != nil {
+					return invalid(ctx, key, "integer", call)
+				}
+				return rt.ToValue(i)
+			},
+			"getFloat": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				key, s := get(call)
+				if s == "" {
+					return call.Argument(1)
+				}
+				f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+				if err != nil {
+					return invalid(ctx, key, "number", call)
+				}
+				return rt.ToValue(f)
+			},
+			"getBool": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				key, s := get(call)
+				if s == "" {
+					return call.Argument(1)
+				}
+				b, err := strconv.ParseBool(strings.TrimSpace(s))
+				if err != nil {
+					return invalid(ctx, key, "boolean", call)
+				}
+				return rt.ToValue(b)
+			},
+			"getDuration": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				key, s := get(call)
+				if s == "" {
+					return call.Argument(1)
+				}
+				d, err := time.ParseDuration(strings.TrimSpace(s))
+				if err != nil {
+					return invalid(ctx, key, "duration", call)
+				}
+				return rt.ToValue(float64(d) / float64(time.Millisecond))
+			},
+		}
+	})
+}
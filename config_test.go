@@ -0,0 +1,85 @@
+package k8
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (cfg *testConfig) StringWithDefault(key, value string) string {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if s, ok := cfg.values[key]; ok {
+		return s
+	}
+	return value
+}
+
+func (cfg *testConfig) set(key, value string) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.values[key] = value
+}
+
+func TestConfigModule(t *testing.T) {
+	cfg := &testConfig{values: map[string]string{
+		"k8.scripts.url":     "http://crm",
+		"k8.scripts.limit":   "10",
+		"k8.scripts.ratio":   "0.25",
+		"k8.scripts.enabled": "true",
+		"k8.scripts.timeout": "1.5s",
+		"k8.scripts.broken":  "abc",
+		"db.password":        "secret",
+	}}
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.RegisterModule("config", ConfigModule(cfg, "k8.scripts."))
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() {
+	return {
+		url: k8.config.getString("url", "none"),
+		missing: k8.config.getString("missing", "none"),
+		limit: k8.config.getInt("limit", 1),
+		broken: k8.config.getInt("broken", 1),
+		ratio: k8.config.getFloat("ratio", 1),
+		enabled: k8.config.getBool("enabled", false),
+		timeout: k8.config.getDuration("timeout", 0),
+		password: k8.config.getString("../db.password", "hidden"),
+		has: k8.config.has("url")
+	};
+};`))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	v, err := r.RunMethod(context.Background(), "a", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"url":      "http://crm",
+		"missing":  "none",
+		"limit":    int64(10),
+		"broken":   int64(1),
+		"ratio":    0.25,
+		"enabled":  true,
+		"timeout":  int64(1500),
+		"password": "hidden",
+		"has":      true,
+	}, v)
+
+	// the changes are seen without rebuilding the scripts.
+	cfg.set("k8.scripts.limit", "20")
+	v, err = r.RunMethod(context.Background(), "a", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), v.(map[string]interface{})["limit"])
+}
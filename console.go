@@ -0,0 +1,204 @@
+package k8
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/runner-mei/log"
+)
+
+//nolint:gochecknoglobals
+var consoleLevels = []string{"log", "debug", "info", "warn", "error"}
+
+// ConsoleEntry is a message written by the script with console.log, console.info, ...
+type ConsoleEntry struct {
+	Level   string    `json:"level"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Source  string    `json:"source,omitempty"`
+}
+
+// ConsoleCapture keeps the console output of an invocation, only the latest limit
+// entries are kept.
+type ConsoleCapture struct {
+	limit   int
+	mu      sync.Mutex
+	entries []ConsoleEntry
+	dropped int
+}
+
+// NewConsoleCapture creates a ConsoleCapture which keeps at most limit entries.
+func NewConsoleCapture(limit int) *ConsoleCapture {
+	if limit <= 0 {
+		limit = 100
+	}
+	return &ConsoleCapture{limit: limit}
+}
+
+func (capture *ConsoleCapture) Add(entry ConsoleEntry) {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	if len(capture.entries) >= capture.limit {
+		copy(capture.entries, capture.entries[1:])
+		capture.entries = capture.entries[:len(capture.entries)-1]
+		capture.dropped++
+	}
+	capture.entries = append(capture.entries, entry)
+}
+
+func (capture *ConsoleCapture) Entries() []ConsoleEntry {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	return append([]ConsoleEntry(nil), capture.entries...)
+}
+
+// Dropped returns the count of the entries which are dropped because the buffer is full.
+func (capture *ConsoleCapture) Dropped() int {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	return capture.dropped
+}
+
+type consoleCaptureKey struct{}
+
+func (key *consoleCaptureKey) String() string {
+	return "console-capture"
+}
+
+//nolint:gochecknoglobals
+var ctxKeyConsoleCapture = &consoleCaptureKey{}
+
+// WithConsoleCapture attaches the capture to the context, the console output of the
+// invocation running with the context is added to it.
+func WithConsoleCapture(ctx context.Context, capture *ConsoleCapture) context.Context {
+	return context.WithValue(ctx, ctxKeyConsoleCapture, capture)
+}
+
+// GetConsoleCapture retrieves the attached capture from the given context.
+func GetConsoleCapture(ctx context.Context) *ConsoleCapture {
+	if ctx == nil {
+		return nil
+	}
+	v := ctx.Value(ctxKeyConsoleCapture)
+	if v == nil {
+		return nil
+	}
+	return v.(*ConsoleCapture)
+}
+
+// installConsole sets the default console of the runtime, the messages are written to the
+// logger of the state and the capture in the context of the invocation.
+func installConsole(rt *gojs.Runtime) {
+	rt.Set("console", newConsole(rt, func(ctx context.Context, level, msg string) {
+		entry := ConsoleEntry{
+			Level:   level,
+			Time:    time.Now(),
+			Message: msg,
+			Source:  callerSource(rt),
+		}
+		if capture := GetConsoleCapture(ctx); capture != nil {
+			capture.Add(entry)
+		}
+
+		if ctx == nil {
+			return
+		}
+		state := lib.GetState(ctx)
+		if state == nil || state.Logger == nil {
+			return
+		}
+		fields := []log.Field{log.String("source", entry.Source)}
+		switch level {
+		case "debug":
+			state.Logger.Debug(msg, fields...)
+		case "warn":
+			state.Logger.Warn(msg, fields...)
+		case "error":
+			state.Logger.Error(msg, fields...)
+		default:
+			state.Logger.Info(msg, fields...)
+		}
+	}))
+}
+
+// newConsole creates a console object, everything written by the script is passed to write.
+func newConsole(rt *gojs.Runtime, write func(ctx context.Context, level, msg string)) *goja.Object {
+	console := rt.NewObject()
+	for _, level := range consoleLevels {
+		level := level
+		_ = console.Set(level, rt.ToValue(func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			write(ctx, level, formatConsoleArgs(call.Arguments))
+			return goja.Undefined()
+		}))
+	}
+	return console
+}
+
+// callerSource returns the location of the script which calls the console.
+func callerSource(rt *gojs.Runtime) string {
+	for _, frame := range rt.CaptureCallStack(4, nil) {
+		frame := frame
+		name := frame.SrcName()
+		if name == "" || name == "<native>" {
+			continue
+		}
+		return name + ":" + strconv.Itoa(frame.Position().Line)
+	}
+	return ""
+}
+
+func formatConsoleArgs(args []goja.Value) string {
+	var sb strings.Builder
+	for i, arg := range args {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		if s, ok := arg.Export().(string); ok {
+			sb.WriteString(s)
+		} else {
+			sb.WriteString(formatValue(arg))
+		}
+	}
+	return sb.String()
+}
+
+// formatValue formats the value for human, objects are converted to indented json.
+func formatValue(v goja.Value) string {
+	if v == nil || goja.IsUndefined(v) {
+		return "undefined"
+	}
+	if goja.IsNull(v) {
+		return "null"
+	}
+
+	obj, ok := v.(*goja.Object)
+	if !ok {
+		if s, ok := v.Export().(string); ok {
+			return strconv.Quote(s)
+		}
+		return v.String()
+	}
+	if _, ok := goja.AssertFunction(obj); ok {
+		return "[Function]"
+	}
+	switch obj.ClassName() {
+	case "Error", "Date", "RegExp":
+		return obj.String()
+	}
+
+	bs, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return obj.String()
+	}
+	return string(bs)
+}
@@ -0,0 +1,60 @@
+package k8
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsoleCapture(t *testing.T) {
+	b, err := getSimpleBuilder("/script.js", `
+		module.exports.default = function() {
+			console.log("a", 1, {b: 2});
+			console.warn("c");
+			for (var i = 0; i < 5; i++) {
+				console.debug("d" + i);
+			}
+		}`, gojs.CompatibilityModeBase)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	r, err := b.Build(ctx, nil)
+	require.NoError(t, err)
+
+	t.Run("NoCapture", func(t *testing.T) {
+		_, err := r.RunDefaultMethod(ctx, goja.Undefined())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Capture", func(t *testing.T) {
+		capture := NewConsoleCapture(10)
+		_, err := r.RunDefaultMethod(WithConsoleCapture(ctx, capture), goja.Undefined())
+		require.NoError(t, err)
+
+		entries := capture.Entries()
+		require.Len(t, entries, 7)
+		assert.Equal(t, "log", entries[0].Level)
+		assert.Equal(t, "a 1 {\n  \"b\": 2\n}", entries[0].Message)
+		assert.Equal(t, "/script.js:3", entries[0].Source)
+		assert.False(t, entries[0].Time.IsZero())
+		assert.Equal(t, "warn", entries[1].Level)
+		assert.Equal(t, "/script.js:4", entries[1].Source)
+		assert.Equal(t, 0, capture.Dropped())
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		capture := NewConsoleCapture(3)
+		_, err := r.RunDefaultMethod(WithConsoleCapture(ctx, capture), goja.Undefined())
+		require.NoError(t, err)
+
+		entries := capture.Entries()
+		require.Len(t, entries, 3)
+		assert.Equal(t, "d2", entries[0].Message)
+		assert.Equal(t, "d4", entries[2].Message)
+		assert.Equal(t, 4, capture.Dropped())
+	})
+}
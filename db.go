@@ -0,0 +1,337 @@
+package k8
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+	"tech.hengwei.com.cn/go/moo"
+)
+
+// A DataSource is a database exposed to the scripts as k8.db.<name>.
+type DataSource struct {
+	Name string
+	DB   *sql.DB
+	// Timeout limits every statement, or the whole transaction, within the deadline of
+	// the invocation. Zero means only the deadline of the invocation applies.
+	Timeout time.Duration
+}
+
+// context returns the context of a statement, it is canceled with the invocation.
+func (ds *DataSource) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ds.Timeout > 0 {
+		return context.WithTimeout(ctx, ds.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// A dbConn is either a *sql.DB or a *sql.Tx.
+type dbConn interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// DBModule exposes the data sources as k8.db, the values are only passed by the parameters
+// of the statements, e.g.
+//
+//	var rows = k8.db.main.query("select id, name from users where age > ?", 18);
+//	var result = k8.db.main.exec("update users set name = ? where id = ?", "k8", 1);
+//	k8.db.main.tx(function(tx) {
+//	  tx.exec("insert into logs(msg) values(?)", "hello");
+//	});
+//	var cursor = k8.db.main.cursor("select * from logs");
+//	for (var row = cursor.next(); row !== null; row = cursor.next()) { ... }
+//	cursor.close();
+//
+// The cursors which aren't closed are closed when the method returns, or when the
+// transaction ends if they are opened by the transaction. A cursor cannot be opened in the
+// top-level code of a script.
+func DBModule(sources map[string]*DataSource) Module {
+	return ModuleFunc(func(rt *gojs.Runtime) interface{} {
+		exports := map[string]interface{}{}
+		for name, ds := range sources {
+			exports[name] = dataSourceExports(rt, ds)
+		}
+		return exports
+	})
+}
+
+func dataSourceExports(rt *gojs.Runtime, ds *DataSource) map[string]interface{} {
+	exports := map[string]interface{}{
+		"tx": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			fn, ok := goja.AssertFunction(call.Argument(0))
+			if !ok {
+				panic(rt.NewTypeError("k8.db." + ds.Name + ".tx: the argument must be a function"))
+			}
+			ctx, cancel := ds.context(ctx)
+			defer cancel()
+			tx, err := ds.DB.BeginTx(ctx, nil)
+			if err != nil {
+				panic(rt.NewGoError(err))
+			}
+
+			// the cursors of the transaction are closed before it ends.
+			var cursors []func()
+			closeCursors := func() {
+				for i := len(cursors) - 1; i >= 0; i-- {
+					cursors[i]()
+				}
+				cursors = nil
+			}
+			obj := rt.NewObject()
+			for name, statement := range statementExports(rt, ds, func(context.Context) (context.Context, context.CancelFunc, dbConn) {
+				// the statements of the transaction share its context.
+				return ctx, func() {}, tx
+			}, func(context.Context) func(func()) {
+				return func(closeRows func()) { cursors = append(cursors, closeRows) }
+			}) {
+				_ = obj.Set(name, rt.ToValue(statement))
+			}
+			v, err := fn(goja.Undefined(), obj)
+			closeCursors()
+			if err != nil {
+				_ = tx.Rollback()
+				return throwIfError(rt, v, err)
+			}
+			if err := tx.Commit(); err != nil {
+				panic(rt.NewGoError(err))
+			}
+			return v
+		},
+	}
+	for name, statement := range statementExports(rt, ds, func(ctx context.Context) (context.Context, context.CancelFunc, dbConn) {
+		ctx, cancel := ds.context(ctx)
+		return ctx, cancel, ds.DB
+	}, func(ctx context.Context) func(func()) {
+		// the cursors are closed when the method returns, there is nothing to close
+		// them in the top-level code.
+		if inv := getInvocation(ctx); inv != nil {
+			return inv.onDone
+		}
+		return nil
+	}) {
+		exports[name] = statement
+	}
+	return exports
+}
+
+// statementExports returns query, exec and cursor, conn returns the connection and the
+// context of a statement, onClose returns the function to register the closing of a
+// cursor which isn't closed by the script, it returns nil if a cursor cannot be opened.
+func statementExports(rt *gojs.Runtime, ds *DataSource,
+	conn func(context.Context) (context.Context, context.CancelFunc, dbConn),
+	onClose func(context.Context) func(func()),
+) map[string]interface{} {
+	return map[string]interface{}{
+		"query": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			query, args := statementArgs(rt, ds, "query", call)
+			ctx, cancel, db := conn(ctx)
+			defer cancel()
+			rows, err := db.QueryContext(ctx, query, args...)
+			if err != nil {
+				panic(rt.NewGoError(err))
+			}
+			defer rows.Close()
+
+			columns, err := rows.Columns()
+			if err != nil {
+				panic(rt.NewGoError(err))
+			}
+			results := []goja.Value{}
+			for rows.Next() {
+				results = append(results, scanRow(rt, rows, columns))
+			}
+			if err := rows.Err(); err != nil {
+				panic(rt.NewGoError(err))
+			}
+			return newArray(rt, results)
+		},
+		"exec": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			query, args := statementArgs(rt, ds, "exec", call)
+			ctx, cancel, db := conn(ctx)
+			defer cancel()
+			result, err := db.ExecContext(ctx, query, args...)
+			if err != nil {
+				panic(rt.NewGoError(err))
+			}
+			obj := rt.NewObject()
+			// some drivers don't support them.
+			if n, err := result.RowsAffected(); err == nil {
+				_ = obj.Set("rowsAffected", n)
+			}
+			if id, err := result.LastInsertId(); err == nil {
+				_ = obj.Set("lastInsertId", id)
+			}
+			return obj
+		},
+		"cursor": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			query, args := statementArgs(rt, ds, "cursor", call)
+			register := onClose(ctx)
+			if register == nil {
+				panic(rt.NewGoError(errors.New("k8.db." + ds.Name + ".cursor: a cursor can only be opened in a method")))
+			}
+			qctx, cancel, db := conn(ctx)
+			rows, err := db.QueryContext(qctx, query, args...)
+			if err != nil {
+				cancel()
+				panic(rt.NewGoError(err))
+			}
+			columns, err := rows.Columns()
+			if err != nil {
+				rows.Close()
+				cancel()
+				panic(rt.NewGoError(err))
+			}
+			closed := false
+			closeRows := func() error {
+				if closed {
+					return nil
+				}
+				closed = true
+				defer cancel()
+				return rows.Close()
+			}
+			register(func() { _ = closeRows() })
+
+			obj := rt.NewObject()
+			_ = obj.Set("next", func(call goja.FunctionCall) goja.Value {
+				if closed {
+					return goja.Null()
+				}
+				if !rows.Next() {
+					err := rows.Err()
+					if closeErr := closeRows(); err == nil {
+						err = closeErr
+					}
+					if err != nil {
+						panic(rt.NewGoError(err))
+					}
+					return goja.Null()
+				}
+				return scanRow(rt, rows, columns)
+			})
+			_ = obj.Set("close", func(call goja.FunctionCall) goja.Value {
+				if err := closeRows(); err != nil {
+					panic(rt.NewGoError(err))
+				}
+				return goja.Undefined()
+			})
+			return obj
+		},
+	}
+}
+
+// statementArgs returns the statement and its parameters, only the scalar values may be
+// bound.
+func statementArgs(rt *gojs.Runtime, ds *DataSource, fn string, call goja.FunctionCall) (string, []interface{}) {
+	if len(call.Arguments) == 0 || goja.IsUndefined(call.Argument(0)) || goja.IsNull(call.Argument(0)) {
+		panic(rt.NewTypeError("k8.db." + ds.Name + "." + fn + ": the statement is missing"))
+	}
+	args := make([]interface{}, 0, len(call.Arguments)-1)
+	for i, v := range call.Arguments[1:] {
+		arg := v.Export()
+		switch arg.(type) {
+		case nil, bool, int64, float64, string, time.Time:
+		default:
+			panic(rt.NewTypeError("k8.db." + ds.Name + "." + fn + ": the parameter " +
+				strconv.Itoa(i+1) + " must be a boolean, a number, a string, a date or null"))
+		}
+		args = append(args, arg)
+	}
+	return call.Argument(0).String(), args
+}
+
+// newArray returns a javascript array of the values, the pinned goja has no
+// Runtime.NewArray.
+func newArray(rt *gojs.Runtime, values []goja.Value) goja.Value {
+	arr, err := rt.New(rt.Get("Array"))
+	if err != nil {
+		panic(err)
+	}
+	push, _ := goja.AssertFunction(arr.Get("push"))
+	if _, err := push(arr, values...); err != nil {
+		panic(err)
+	}
+	return arr
+}
+
+// scanRow returns the current row as an object, the keys are the names of the columns.
+func scanRow(rt *gojs.Runtime, rows *sql.Rows, columns []string) goja.Value {
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		panic(rt.NewGoError(err))
+	}
+	row := rt.NewObject()
+	for i, column := range columns {
+		switch v := values[i].(type) {
+		case []byte:
+			_ = row.Set(column, string(v))
+		case time.Time:
+			date, err := rt.New(rt.Get("Date"), rt.ToValue(v.UnixNano()/int64(time.Millisecond)))
+			if err != nil {
+				panic(err)
+			}
+			_ = row.Set(column, date)
+		default:
+			_ = row.Set(column, v)
+		}
+	}
+	return row
+}
+
+// openDataSources opens the data sources in K8_DB_SOURCES, they are separated by commas,
+// e.g. for "main":
+//
+//	K8_DB_SOURCES=main
+//	K8_DB_MAIN_DRIVER=postgres
+//	K8_DB_MAIN_URL=host=127.0.0.1 user=k8 dbname=k8
+//	K8_DB_MAIN_TIMEOUT=30s
+//	K8_DB_MAIN_MAX_OPEN_CONNS=10
+//
+// The drivers must be imported by the application.
+func openDataSources(env *moo.Environment) (map[string]*DataSource, error) {
+	sources := map[string]*DataSource{}
+	for _, name := range strings.Split(env.Config.StringWithDefault("K8_DB_SOURCES", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "K8_DB_" + strings.ToUpper(name) + "_"
+		driver := env.Config.StringWithDefault(prefix+"DRIVER", "")
+		url := env.Config.StringWithDefault(prefix+"URL", "")
+		if driver == "" || url == "" {
+			closeDataSources(sources)
+			return nil, errors.New(prefix + "DRIVER and " + prefix + "URL are required")
+		}
+		db, err := sql.Open(driver, url)
+		if err != nil {
+			closeDataSources(sources)
+			return nil, errors.Wrap(err, "open data source '"+name+"'")
+		}
+		db.SetMaxOpenConns(intWithDefault(env, prefix+"MAX_OPEN_CONNS", 10))
+		sources[name] = &DataSource{
+			Name:    name,
+			DB:      db,
+			Timeout: durationWithDefault(env, prefix+"TIMEOUT", 30*time.Second),
+		}
+	}
+	return sources, nil
+}
+
+func closeDataSources(sources map[string]*DataSource) {
+	for _, ds := range sources {
+		ds.DB.Close()
+	}
+}
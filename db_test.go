@@ -0,0 +1,159 @@
+package k8
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBModule(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`create table users(id integer primary key, name text, age integer)`)
+	require.NoError(t, err)
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.RegisterModule("db", DBModule(map[string]*DataSource{
+		"main": {Name: "main", DB: db, Timeout: time.Second},
+	}))
+	require.NoError(t, b.Compile("/add.js", `exports.meta = {id: "add"};
+exports.default = function(args) {
+	return k8.db.main.exec("insert into users(name, age) values(?, ?)", args.name, args.age);
+};`))
+	require.NoError(t, b.Compile("/list.js", `exports.meta = {id: "list"};
+exports.default = function(args) {
+	return k8.db.main.query("select name, age from users where age >= ? order by id", args.age);
+};`))
+	require.NoError(t, b.Compile("/tx.js", `exports.meta = {id: "tx"};
+exports.default = function(args) {
+	k8.db.main.tx(function(tx) {
+		tx.exec("insert into users(name, age) values(?, ?)", "tx", 1);
+		if (args.fail) {
+			throw new Error("rollback");
+		}
+	});
+};`))
+	require.NoError(t, b.Compile("/names.js", `exports.meta = {id: "names"};
+exports.default = function(args) {
+	var names = [];
+	var cursor = k8.db.main.cursor("select name from users order by id");
+	for (var row = cursor.next(); row !== null; row = cursor.next()) {
+		names.push(row.name);
+		if (names.length == args.limit) {
+			break; // the cursor is closed when the method returns.
+		}
+	}
+	return names.join(",");
+};`))
+	require.NoError(t, b.Compile("/txcursor.js", `exports.meta = {id: "txcursor"};
+exports.default = function() {
+	return k8.db.main.tx(function(tx) {
+		tx.exec("insert into users(name, age) values(?, ?)", "txcursor", 2);
+		var cursor = tx.cursor("select name from users order by id");
+		return cursor.next().name; // the cursor is closed before the commit.
+	});
+};`))
+	require.NoError(t, b.Compile("/inject.js", `exports.meta = {id: "inject"};
+exports.default = function() { return k8.db.main.query("select * from users where name = ?", {name: "a"}); };`))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	v, err := r.RunMethod(context.Background(), "add", map[string]interface{}{"name": "a", "age": 20})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"rowsAffected": int64(1), "lastInsertId": int64(1)}, v)
+	_, err = r.RunMethod(context.Background(), "add", map[string]interface{}{"name": "b", "age": 10})
+	require.NoError(t, err)
+
+	v, err = r.RunMethod(context.Background(), "list", map[string]interface{}{"age": 18})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "a", "age": int64(20)}}, v)
+
+	_, err = r.RunMethod(context.Background(), "tx", map[string]interface{}{"fail": true})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "rollback")
+	}
+	_, err = r.RunMethod(context.Background(), "tx", map[string]interface{}{})
+	require.NoError(t, err)
+
+	v, err = r.RunMethod(context.Background(), "names", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, "a,b,tx", v)
+	v, err = r.RunMethod(context.Background(), "names", map[string]interface{}{"limit": 1})
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+	// all connections are released, even the one of the cursor which isn't closed.
+	assert.Equal(t, 0, db.Stats().InUse)
+
+	v, err = r.RunMethod(context.Background(), "txcursor", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+	assert.Equal(t, 0, db.Stats().InUse)
+	v, err = r.RunMethod(context.Background(), "names", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, "a,b,tx,txcursor", v)
+
+	_, err = r.RunMethod(context.Background(), "inject", nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the parameter 1 must be")
+	}
+}
+
+func TestDBModuleTopLevelCursor(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.RegisterModule("db", DBModule(map[string]*DataSource{
+		"main": {Name: "main", DB: db},
+	}))
+	require.NoError(t, b.Compile("/top.js", `exports.meta = {id: "top"};
+var cursor = k8.db.main.cursor("select 1 as n");
+exports.default = function() { return cursor.next(); };`))
+	_, err = b.Build(context.Background(), nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "k8.db.main.cursor: a cursor can only be opened in a method")
+	}
+	assert.Equal(t, 0, db.Stats().InUse)
+}
+
+func TestDBModuleTimeout(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.RegisterModule("db", DBModule(map[string]*DataSource{
+		"main": {Name: "main", DB: db},
+	}))
+	require.NoError(t, b.Compile("/slow.js", `exports.meta = {id: "slow"};
+exports.default = function() {
+	return k8.db.main.query("with recursive n(i) as (select 1 union all select i + 1 from n) select count(*) as c from n");
+};`))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err = r.RunMethod(ctx, "slow", nil)
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(started)), int64(5*time.Second))
+}
@@ -0,0 +1,82 @@
+package k8
+
+import (
+	"github.com/runner-mei/loong"
+)
+
+// debugResult is the response in the debug mode, it is returned when the request has
+// the header "X-K8-Debug: true" and the caller is allowed to see the console output.
+type debugResult struct {
+	Result  interface{}    `json:"result"`
+	Console []ConsoleEntry `json:"console"`
+	Dropped int            `json:"console_dropped,omitempty"`
+}
+
+type debugMode struct {
+	adminToken string
+	// everyone can see the console output, otherwise only admin can see it.
+	public       bool
+	consoleLimit int
+}
+
+func isDebugRequest(c *loong.Context) bool {
+	switch c.Request().Header.Get("X-K8-Debug") {
+	case "true", "1", "on":
+		return true
+	}
+	return false
+}
+
+// capture returns nil if the caller isn't allowed to see the console output.
+func (d *debugMode) capture(c *loong.Context) *ConsoleCapture {
+	if !d.public && !isAdmin(c, d.adminToken) {
+		return nil
+	}
+	return NewConsoleCapture(d.consoleLimit)
+}
+
+func (d *debugMode) returnResult(c *loong.Context, capture *ConsoleCapture, result interface{}) error {
+	if capture == nil || !isDebugRequest(c) {
+		return c.ReturnQueryResult(result)
+	}
+	return c.ReturnQueryResult(&debugResult{
+		Result:  result,
+		Console: capture.Entries(),
+		Dropped: capture.Dropped(),
+	})
+}
+
+// returnError attaches the original source lines of the positions in the error to the
+// data of the error, and the console output if the caller is allowed to see it.
+func (d *debugMode) returnError(c *loong.Context, capture *ConsoleCapture, err error) error {
+	var entries []ConsoleEntry
+	if capture != nil {
+		entries = capture.Entries()
+	}
+	source := errorSource(err)
+	if len(entries) == 0 && len(source) == 0 {
+		return c.ReturnError(err)
+	}
+
+	e := *loong.ToError(err)
+	fields := make(map[string][]string, len(e.Fields)+2)
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+	if len(entries) > 0 {
+		lines := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			line := entry.Time.Format("2006-01-02 15:04:05.000") + " [" + entry.Level + "] " + entry.Message
+			if entry.Source != "" {
+				line += " (" + entry.Source + ")"
+			}
+			lines = append(lines, line)
+		}
+		fields["console"] = lines
+	}
+	if len(source) > 0 {
+		fields["source"] = source
+	}
+	e.Fields = fields
+	return c.ReturnError(&e, e.HTTPCode())
+}
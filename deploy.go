@@ -0,0 +1,88 @@
+package k8
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/runner-mei/gojs/lib"
+)
+
+// A deployment is the set of the scripts in service with the pool of their runners. It is
+// replaced as a whole when the scripts are reloaded, so a request never sees a deployment
+// which is half applied.
+type deployment struct {
+	builder *Builder
+	pool    *Pool
+	methods []map[string]interface{}
+	// warnings are the problems of the meta descriptions.
+	warnings []string
+}
+
+func newDeployment(ctx context.Context, b *Builder, size int) (*deployment, error) {
+	runners := make([]*Runner, 0, size)
+	for i := 0; i < size; i++ {
+		r, err := b.Build(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		runners = append(runners, r)
+	}
+
+	// the pool is created after all runners are built, so the metrics never refer to
+	// the pool of a failed deployment.
+	d := &deployment{
+		builder: b,
+		pool:    NewPool(size, b.Metrics()),
+	}
+	d.pool.New = func() (*Runner, error) {
+		return b.Build(context.Background(), nil)
+	}
+	for i, r := range runners {
+		if i == 0 {
+			d.methods = r.methodList()
+			d.warnings = metaWarnings(r)
+		}
+		d.pool.Add(r)
+	}
+	return d, nil
+}
+
+// deployments keeps the current deployment, the runners in use are returned to the pool
+// of their own deployment after it is replaced.
+type deployments struct {
+	mu      sync.Mutex
+	current atomic.Value
+}
+
+func (ds *deployments) get() *deployment {
+	return ds.current.Load().(*deployment)
+}
+
+func (ds *deployments) set(d *deployment) {
+	ds.current.Store(d)
+}
+
+// reload creates a new deployment and replaces the current one only if it succeeds, the
+// reloads are serialized.
+func (ds *deployments) reload(create func() (*deployment, error)) (*deployment, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	d, err := create()
+	if err != nil {
+		return nil, err
+	}
+	ds.set(d)
+	return d, nil
+}
+
+// warnMeta logs the problems of the meta descriptions of the deployment.
+func warnMeta(state *lib.State, d *deployment) {
+	if state == nil || state.Logger == nil {
+		return
+	}
+	for _, msg := range d.warnings {
+		state.Logger.Warn("invalid meta description, " + msg)
+	}
+}
@@ -0,0 +1,70 @@
+package k8
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/runner-mei/gojs"
+)
+
+// Deterministic makes the invocations reproducible, e.g. for the golden tests of the
+// output of the scripts. While a method is running, Date returns the frozen instant,
+// Math.random returns the same sequence on every call, and __ENV is replaced with Env.
+type Deterministic struct {
+	// Now is the frozen instant, it is the Unix epoch if it is zero.
+	Now time.Time
+	// Seed seeds Math.random, the generator is reset on every call.
+	Seed int64
+	// Env replaces the environment variables, it is empty if it is nil.
+	Env map[string]string
+}
+
+type deterministicKey struct{}
+
+func (key *deterministicKey) String() string {
+	return "deterministic"
+}
+
+//nolint:gochecknoglobals
+var ctxKeyDeterministic = &deterministicKey{}
+
+// WithDeterministic returns a context which runs the methods in the deterministic mode,
+// it overrides the mode set by Builder.SetDeterministic.
+func WithDeterministic(ctx context.Context, d *Deterministic) context.Context {
+	return context.WithValue(ctx, ctxKeyDeterministic, d)
+}
+
+func getDeterministic(ctx context.Context) *Deterministic {
+	if ctx == nil {
+		return nil
+	}
+	v := ctx.Value(ctxKeyDeterministic)
+	if v == nil {
+		return nil
+	}
+	return v.(*Deterministic)
+}
+
+// freeze applies the deterministic mode to the runtime, it returns the function which
+// restores the runtime.
+func freeze(rt *gojs.Runtime, d *Deterministic) func() {
+	now := d.Now
+	if now.IsZero() {
+		now = time.Unix(0, 0).UTC()
+	}
+	env := make(map[string]string, len(d.Env))
+	for k, v := range d.Env {
+		env[k] = v
+	}
+
+	old := rt.Get("__ENV")
+	rt.SetTimeSource(func() time.Time { return now })
+	rt.SetRandSource(rand.New(rand.NewSource(d.Seed)).Float64) //nolint:gosec
+	rt.Set("__ENV", env)
+	return func() {
+		rt.SetTimeSource(time.Now)
+		rt.SetRandSource(gojs.NewRandSource())
+		rt.Set("__ENV", old)
+	}
+}
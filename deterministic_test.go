@@ -0,0 +1,65 @@
+package k8
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeterministic(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+		Env:               map[string]string{"FOO": "system"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() {
+	return [Date.now(), new Date().getTime(), Math.random(), Math.random(), __ENV.FOO].join(",");
+};`))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ctx := WithDeterministic(context.Background(), &Deterministic{
+		Now:  now,
+		Seed: 42,
+		Env:  map[string]string{"FOO": "fixed"},
+	})
+	first, err := r.RunMethod(ctx, "a", nil)
+	require.NoError(t, err)
+	second, err := r.RunMethod(ctx, "a", nil)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Regexp(t, `^1577934245000,1577934245000,0\.\d+,0\.\d+,fixed$`, first)
+
+	// the runtime is restored after the call.
+	v, err := r.RunMethod(context.Background(), "a", nil)
+	require.NoError(t, err)
+	assert.Regexp(t, `,system$`, v)
+	assert.NotEqual(t, first, v)
+}
+
+func TestBuilderDeterministic(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.SetDeterministic(&Deterministic{Seed: 1})
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() { return Date.now() + ":" + Math.random(); };`))
+
+	var results []interface{}
+	for i := 0; i < 2; i++ {
+		r, err := b.Build(context.Background(), nil)
+		require.NoError(t, err)
+		v, err := r.RunMethod(context.Background(), "a", nil)
+		require.NoError(t, err)
+		results = append(results, v)
+	}
+	assert.Equal(t, results[0], results[1])
+	assert.Regexp(t, `^0:`, results[0])
+}
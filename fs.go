@@ -0,0 +1,237 @@
+package k8
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+	"golang.org/x/tools/godoc/vfs"
+)
+
+// ErrPathTraversal is returned if a path of k8.fs contains "..".
+//
+//nolint:gochecknoglobals
+var ErrPathTraversal = errors.New("path traversal isn't allowed")
+
+// scriptFS is the read-only view of the namespace, only the files under the prefixes are
+// visible.
+type scriptFS struct {
+	fs       vfs.NameSpace
+	prefixes []string
+}
+
+func newScriptFS(fs vfs.NameSpace, prefixes []string) *scriptFS {
+	sfs := &scriptFS{fs: fs}
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			sfs.prefixes = append(sfs.prefixes, path.Clean("/"+prefix))
+		}
+	}
+	return sfs
+}
+
+// cleanPath returns the clean absolute path, the path must not go up with "..".
+func cleanPath(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", errors.New("invalid path '" + name + "'")
+	}
+	for _, elem := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return "", errors.Wrap(ErrPathTraversal, name)
+		}
+	}
+	return path.Clean("/" + name), nil
+}
+
+// resolve returns the clean absolute path of the file, the file must be under one of the
+// prefixes and be allowed by the sandbox of the running method.
+func (sfs *scriptFS) resolve(ctx context.Context, name string) (string, error) {
+	name, err := cleanPath(name)
+	if err != nil {
+		return "", err
+	}
+	if !sfs.visible(name) {
+		return "", &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	if err := checkPath(ctx, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (sfs *scriptFS) visible(name string) bool {
+	for _, prefix := range sfs.prefixes {
+		if prefix == "/" || name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// glob returns the visible files matching the pattern, the elements of the pattern have
+// the syntax of path.Match.
+func (sfs *scriptFS) glob(ctx context.Context, pattern string) ([]string, error) {
+	// the matches are checked one by one, the pattern may start above the prefixes.
+	pattern, err := cleanPath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.Wrap(err, pattern)
+	}
+
+	// the directories before the first element with the meta characters are walked
+	// directly.
+	elems := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	dir := "/"
+	for len(elems) > 0 && !strings.ContainsAny(elems[0], `*?[\`) {
+		dir = path.Join(dir, elems[0])
+		elems = elems[1:]
+	}
+	if len(elems) == 0 {
+		if _, err := sfs.fs.Stat(dir); err != nil || sfs.resolveQuietly(ctx, dir) == "" {
+			return []string{}, nil
+		}
+		return []string{dir}, nil
+	}
+
+	matches := []string{}
+	var walk func(dir string, elems []string)
+	walk = func(dir string, elems []string) {
+		infos, err := sfs.fs.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, info := range infos {
+			if ok, _ := path.Match(elems[0], info.Name()); !ok {
+				continue
+			}
+			name := path.Join(dir, info.Name())
+			if len(elems) > 1 {
+				if info.IsDir() {
+					walk(name, elems[1:])
+				}
+			} else if sfs.resolveQuietly(ctx, name) != "" {
+				matches = append(matches, name)
+			}
+		}
+	}
+	walk(dir, elems)
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (sfs *scriptFS) resolveQuietly(ctx context.Context, name string) string {
+	name, err := sfs.resolve(ctx, name)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+func fileInfoValue(rt *gojs.Runtime, name string, info os.FileInfo) goja.Value {
+	obj := rt.NewObject()
+	_ = obj.Set("name", info.Name())
+	_ = obj.Set("path", name)
+	_ = obj.Set("size", info.Size())
+	_ = obj.Set("isDir", info.IsDir())
+	modTime, err := rt.New(rt.Get("Date"), rt.ToValue(info.ModTime().UnixNano()/1e6))
+	if err != nil {
+		panic(err)
+	}
+	_ = obj.Set("modTime", modTime)
+	return obj
+}
+
+// FSModule exposes the files under the prefixes of the namespace as k8.fs, they are
+// read-only, e.g.
+//
+//	var text = k8.fs.readFile("/data/users.csv");          // utf-8 text
+//	var buffer = k8.fs.readFile("/data/logo.png", "binary"); // ArrayBuffer
+//	var table = k8.fs.readJSON("/data/table.json");
+//	var info = k8.fs.stat("/data/table.json");              // null if it doesn't exist
+//	var names = k8.fs.readdir("/data");
+//	var files = k8.fs.glob("/data/*/*.csv");
+//
+// The files are also restricted by meta.fs of the method.
+func FSModule(fs vfs.NameSpace, prefixes []string) Module {
+	sfs := newScriptFS(fs, prefixes)
+	return ModuleFunc(func(rt *gojs.Runtime) interface{} {
+		resolve := func(ctx context.Context, call goja.FunctionCall) string {
+			name, err := sfs.resolve(ctx, call.Argument(0).String())
+			if err != nil {
+				panic(rt.NewGoError(err))
+			}
+			return name
+		}
+		readFile := func(ctx context.Context, call goja.FunctionCall) []byte {
+			data, err := vfs.ReadFile(sfs.fs, resolve(ctx, call))
+			if err != nil {
+				panic(rt.NewGoError(err))
+			}
+			return data
+		}
+
+		return map[string]interface{}{
+			"readFile": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				data := readFile(ctx, call)
+				switch encoding := call.Argument(1); {
+				case goja.IsUndefined(encoding), goja.IsNull(encoding):
+					return rt.ToValue(string(data))
+				case strings.EqualFold(encoding.String(), "utf8"), strings.EqualFold(encoding.String(), "utf-8"):
+					return rt.ToValue(string(data))
+				case encoding.String() == "binary":
+					return rt.ToValue(rt.NewArrayBuffer(data))
+				default:
+					panic(rt.NewTypeError("k8.fs.readFile: unsupported encoding '" + encoding.String() + "'"))
+				}
+			},
+			"readJSON": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				var v interface{}
+				if err := json.Unmarshal(readFile(ctx, call), &v); err != nil {
+					panic(rt.NewGoError(errors.Wrap(err, call.Argument(0).String())))
+				}
+				return rt.ToValue(v)
+			},
+			"stat": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				name := resolve(ctx, call)
+				info, err := sfs.fs.Stat(name)
+				if err != nil {
+					if os.IsNotExist(err) {
+						return goja.Null()
+					}
+					panic(rt.NewGoError(err))
+				}
+				return fileInfoValue(rt, name, info)
+			},
+			"readdir": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				infos, err := sfs.fs.ReadDir(resolve(ctx, call))
+				if err != nil {
+					panic(rt.NewGoError(err))
+				}
+				names := make([]interface{}, 0, len(infos))
+				for _, info := range infos {
+					names = append(names, info.Name())
+				}
+				return rt.ToValue(names)
+			},
+			"glob": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				matches, err := sfs.glob(ctx, call.Argument(0).String())
+				if err != nil {
+					panic(rt.NewGoError(err))
+				}
+				values := make([]interface{}, 0, len(matches))
+				for _, name := range matches {
+					values = append(values, name)
+				}
+				return rt.ToValue(values)
+			},
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package k8
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/godoc/vfs"
+	"golang.org/x/tools/godoc/vfs/mapfs"
+)
+
+func TestFSModule(t *testing.T) {
+	ns := vfs.NameSpace{}
+	ns.Bind("/", mapfs.New(map[string]string{
+		"data/a.txt":          "hello",
+		"data/table.json":     `{"a": [1, 2]}`,
+		"data/csv/users.csv":  "id,name",
+		"data/csv/groups.csv": "id,name",
+		"secret/key.pem":      "secret",
+	}), "/", vfs.BindReplace)
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.RegisterModule("fs", FSModule(ns, []string{"/data"}))
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function(args) {
+	switch (args.op) {
+	case "readFile":
+		return k8.fs.readFile(args.name);
+	case "binary":
+		return new Uint8Array(k8.fs.readFile(args.name, "binary")).length;
+	case "readJSON":
+		return k8.fs.readJSON(args.name);
+	case "stat":
+		var info = k8.fs.stat(args.name);
+		return info && [info.name, info.size, info.isDir].join(",");
+	case "readdir":
+		return k8.fs.readdir(args.name).join(",");
+	case "glob":
+		return k8.fs.glob(args.name).join(",");
+	}
+};`))
+	require.NoError(t, b.Compile("/sandboxed.js", `exports.meta = {id: "sandboxed", fs: ["/data/csv"]};
+exports.default = function(args) { return k8.fs.readFile(args.name); };`))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	call := func(op, name string) (interface{}, error) {
+		return r.RunMethod(context.Background(), "a", map[string]interface{}{"op": op, "name": name})
+	}
+	for _, test := range []struct {
+		op, name string
+		expected interface{}
+	}{
+		{"readFile", "/data/a.txt", "hello"},
+		{"readFile", "data/./a.txt", "hello"},
+		{"binary", "/data/a.txt", int64(5)},
+		{"readJSON", "/data/table.json", map[string]interface{}{"a": []interface{}{float64(1), float64(2)}}},
+		{"stat", "/data/a.txt", "a.txt,5,false"},
+		{"stat", "/data/missing.txt", nil},
+		{"readdir", "/data/csv", "groups.csv,users.csv"},
+		{"glob", "/data/*/*.csv", "/data/csv/groups.csv,/data/csv/users.csv"},
+		{"glob", "/*/*", "/data/a.txt,/data/csv,/data/table.json"},
+	} {
+		v, err := call(test.op, test.name)
+		if assert.NoError(t, err, test.op+" "+test.name) {
+			assert.Equal(t, test.expected, v, test.op+" "+test.name)
+		}
+	}
+
+	for _, name := range []string{"/secret/key.pem", "/data/../secret/key.pem", "/data/..\\secret/key.pem"} {
+		_, err := call("readFile", name)
+		assert.Error(t, err, name)
+	}
+	_, err = call("readFile", "/data/../secret/key.pem")
+	assert.Contains(t, err.Error(), ErrPathTraversal.Error())
+
+	v, err := r.RunMethod(context.Background(), "sandboxed", map[string]interface{}{"name": "/data/csv/users.csv"})
+	require.NoError(t, err)
+	assert.Equal(t, "id,name", v)
+	_, err = r.RunMethod(context.Background(), "sandboxed", map[string]interface{}{"name": "/data/a.txt"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission denied")
+	}
+}
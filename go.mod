@@ -6,11 +6,20 @@ toolchain go1.21.13
 
 require (
 	github.com/dop251/goja v0.0.0-20200811154920-cd0eddb06559
+	github.com/evanw/esbuild v0.23.1
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/pkg/errors v0.9.1
 	github.com/runner-mei/gojs v0.0.0-20210206043126-1efdbe9923df
+	github.com/runner-mei/log v1.0.10
 	github.com/runner-mei/loong v1.1.31
 	github.com/stretchr/testify v1.9.0
+	go.etcd.io/bbolt v1.3.10
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/sdk v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+	golang.org/x/net v0.28.0
 	golang.org/x/tools v0.24.0
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	tech.hengwei.com.cn/go/goutils v0.0.0-20240826040216-a43ce8e9b823
 	tech.hengwei.com.cn/go/moo v0.0.0-20240826112445-5f52b25f2486
 )
@@ -40,6 +49,8 @@ require (
 	github.com/go-asn1-ber/asn1-ber v1.5.3 // indirect
 	github.com/go-kit/kit v0.9.0 // indirect
 	github.com/go-ldap/ldap/v3 v3.4.1 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/go-openapi/jsonpointer v0.21.0 // indirect
 	github.com/go-openapi/jsonreference v0.21.0 // indirect
 	github.com/go-openapi/spec v0.21.0 // indirect
@@ -51,6 +62,7 @@ require (
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/golang-jwt/jwt/v4 v4.5.1-0.20230219130118-4fd5621d8dd0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
 	github.com/grsmv/inflect v0.0.0-20140723132642-a28d3de3b3ad // indirect
 	github.com/hjson/hjson-go/v4 v4.4.0 // indirect
 	github.com/josharian/intern v1.0.0 // indirect
@@ -84,7 +96,6 @@ require (
 	github.com/richardlehane/msoleps v1.0.3 // indirect
 	github.com/runner-mei/GoBatis v1.5.11 // indirect
 	github.com/runner-mei/errors v0.0.0-20240518070408-3d537385c425 // indirect
-	github.com/runner-mei/log v1.0.10 // indirect
 	github.com/runner-mei/resty v0.0.0-20240826100058-d0acbdc37af6 // indirect
 	github.com/runner-mei/validation v0.0.0-20220506125651-db9c5a72845f // indirect
 	github.com/serenize/snaker v0.0.0-20171204205717-a683aaf2d516 // indirect
@@ -102,21 +113,20 @@ require (
 	github.com/xuri/efp v0.0.0-20230802181842-ad255f2331ca // indirect
 	github.com/xuri/excelize/v2 v2.8.1-0.20231010160438-d9a0da7b48ba // indirect
 	github.com/xuri/nfp v0.0.0-20230919160717-d98342af3f05 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
 	go.uber.org/atomic v1.11.0 // indirect
 	go.uber.org/dig v1.14.0 // indirect
-	go.uber.org/fx v1.17.0 // indirect
+	go.uber.org/fx v1.17.0
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.27.0 // indirect
 	golang.org/x/crypto v0.26.0 // indirect
 	golang.org/x/exp/errors v0.0.0-20240823005443-9b4947da3948 // indirect
 	golang.org/x/image v0.11.0 // indirect
-	golang.org/x/net v0.28.0 // indirect
 	golang.org/x/sys v0.24.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 	golang.org/x/time v0.6.0 // indirect
 	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
 	gopkg.in/guregu/null.v3 v3.4.0 // indirect
-	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -2,41 +2,172 @@ package k8
 
 import (
 	"context"
-	"io/ioutil"
+	"crypto/subtle"
+	"io"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/runner-mei/gojs"
 	"github.com/runner-mei/gojs/lib"
 	"github.com/runner-mei/loong"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
 	"tech.hengwei.com.cn/go/moo"
 	"golang.org/x/tools/godoc/vfs"
 )
 
+// New compiles the scripts in the namespace, the bundles (*.zip, *.tar.gz) in filenames
+// are mounted at /bundles/<name> and their entries are compiled.
 func New(env *moo.Environment, fs vfs.NameSpace, filenames []string) (*Builder, error) {
+	return newWithAuditor(env, fs, filenames, nil, nil)
+}
+
+// newWithAuditor is same as New, the checks of the signatures are recorded by the auditor.
+// The modules are shared by the reloaded builders, such as k8.kv and k8.db.
+func newWithAuditor(env *moo.Environment, fs vfs.NameSpace, filenames []string, auditor *Auditor, modules map[string]Module) (*Builder, error) {
 	// logger := env.Logger
+	verifier, err := newVerifier(env, auditor)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, filenames, bundles, err := mountBundles(fs, filenames, verifier)
+	if err != nil {
+		return nil, err
+	}
+	// the entries of the bundles are covered by the signatures of the bundles.
+	inBundles := map[string]bool{}
+	for _, bundle := range bundles {
+		for _, entry := range bundle.Entries() {
+			inBundles[entry] = true
+		}
+	}
+
 	opts := &gojs.RuntimeOptions{
 		IncludeSystemEnvVars: strings.ToLower(env.Config.StringWithDefault("K8_INCLUDE_SYSTEM_ENV_VARS", "true")) == "true",
 		CompatibilityMode:    env.Config.StringWithDefault("K8_COMPATIBILITY_MODE", ""),
 	}
+	for _, bundle := range bundles {
+		for k, v := range bundle.Manifest.Config {
+			if opts.Env == nil {
+				opts.Env = map[string]string{}
+			}
+			// the system environment variables override the config of the bundles.
+			if _, ok := opts.Env[k]; !ok {
+				opts.Env[k] = v
+			}
+		}
+	}
 
 	builder, err := NewBuilder(opts)
 	if err != nil {
 		return nil, err
 	}
+	builder.SetMetrics(DefaultMetrics)
+	builder.SetSandboxStrict(strings.ToLower(env.Config.StringWithDefault("K8_SANDBOX_STRICT", "false")) == "true")
+	builder.SetBudget(Budget{
+		HeapGuard: int64(intWithDefault(env, "K8_HEAP_GUARD", 0)),
+		Result:    int64(intWithDefault(env, "K8_RESULT_LIMIT", 0)),
+		Interval:  durationWithDefault(env, "K8_HEAP_SAMPLE_INTERVAL", 10*time.Millisecond),
+	})
+	// the files shipped in the bundles are always visible to k8.fs and k8.template.
+	prefixes := strings.Split(env.Config.StringWithDefault("K8_FS_PREFIXES", ""), ",")
+	for _, bundle := range bundles {
+		prefixes = append(prefixes, bundle.MountPoint())
+	}
+	builder.RegisterModule("fs", FSModule(fs, prefixes))
+	builder.RegisterModule("template", TemplateModule(fs, prefixes))
+	for name, module := range modules {
+		builder.RegisterModule(name, module)
+	}
+	for _, bundle := range bundles {
+		limit := bundle.limits()
+		for _, entry := range bundle.Entries() {
+			builder.limitCapabilities(entry, limit)
+		}
+	}
 
+	// all scripts are compiled, so that every syntax error is reported at once. The meta
+	// descriptions are validated by Check, the problems are only logged in service.
+	var problems []Problem
 	for _, filename := range filenames {
 		data, err := vfs.ReadFile(fs, filename)
 		if err != nil {
 			return nil, err
 		}
+		if verifier != nil && !inBundles[filename] {
+			if err := verifier.Verify(fs, filename, data); err != nil {
+				return nil, err
+			}
+		}
+		if err := loadSourceMap(builder, fs, filename, string(data)); err != nil {
+			return nil, err
+		}
 		err = builder.Compile(filename, string(data))
 		if err != nil {
-			return nil, err
+			problems = append(problems, problemOf(filename, err))
 		}
 	}
+	if err := newCheckError(problems); err != nil {
+		return nil, err
+	}
 	return builder, nil
 }
 
+// newVerifier returns nil if the signatures aren't required, the public keys are separated
+// by commas in K8_SIGNATURE_KEYS.
+func newVerifier(env *moo.Environment, auditor *Auditor) (*Verifier, error) {
+	if strings.ToLower(env.Config.StringWithDefault("K8_SIGNATURE_REQUIRED", "false")) != "true" {
+		return nil, nil
+	}
+	keys, err := ParsePublicKeys(env.Config.StringWithDefault("K8_SIGNATURE_KEYS", ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "K8_SIGNATURE_KEYS")
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("K8_SIGNATURE_KEYS is required if K8_SIGNATURE_REQUIRED is true")
+	}
+	return &Verifier{Keys: keys, Auditor: auditor}, nil
+}
+
+// loadSourceMap reads the source map of the script from the namespace, it is the file
+// in the "//# sourceMappingURL=" comment, or the ".map" file next to the script.
+func loadSourceMap(builder *Builder, fs vfs.NameSpace, filename, code string) error {
+	if isTypeScript(filename) {
+		return nil
+	}
+	url := sourceMappingURL(code)
+	if strings.HasPrefix(url, "data:") {
+		return nil
+	}
+
+	mapfile := filename + ".map"
+	if url != "" && !strings.Contains(url, "://") {
+		mapfile = path.Join(path.Dir(filename), url)
+	}
+	data, err := vfs.ReadFile(fs, mapfile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := builder.SetSourceMap(filename, data); err != nil {
+		return errors.Wrap(err, mapfile)
+	}
+	builder.sourceMaps[filename].loadContents(func(source string) ([]byte, error) {
+		if !path.IsAbs(source) {
+			source = path.Join(path.Dir(mapfile), source)
+		}
+		return vfs.ReadFile(fs, source)
+	})
+	return nil
+}
+
 type OutFiles struct {
 	moo.Out
 
@@ -49,100 +180,173 @@ type InFiles struct {
 	Filenames [][]string `group:"k8_script_files"`
 }
 
+// InAudit supplies the optional audit sink and the function to get the user of a request,
+// the records are written to the file K8_AUDIT_FILE if no sink is provided.
+type InAudit struct {
+	moo.In
+
+	Sink     AuditSink     `optional:"true"`
+	UserFunc AuditUserFunc `optional:"true"`
+}
+
+// InTracing supplies the optional tracer provider of the spans of the methods, the global
+// tracer provider of OpenTelemetry is used if no provider is supplied.
+type InTracing struct {
+	moo.In
+
+	TracerProvider trace.TracerProvider `optional:"true"`
+}
+
 func init() {
 	moo.On(func(*moo.Environment) moo.Option {
-		return moo.Invoke(func(env *moo.Environment, fs vfs.NameSpace, infilenames InFiles, httpSrv *moo.HTTPServer) error {
+		return moo.Invoke(func(lc fx.Lifecycle, env *moo.Environment, fs vfs.NameSpace, infilenames InFiles, httpSrv *moo.HTTPServer, audit InAudit, tracing InTracing) error {
 			filenames := make([]string, 0, 64)
 			for _, nm := range infilenames.Filenames {
 				for _, n := range nm {
 					filenames = append(filenames, n)
 				}
 			}
-			b, err := New(env, fs, filenames)
+			auditor := newAuditor(env, audit)
+			kv, err := newKVStore(env)
 			if err != nil {
 				return err
 			}
-
-			var results []map[string]interface{}
-
-			ctx := context.Background()
-			pool := make(chan *Runner, 100)
-			for i := 0; i < 100; i++ {
-				r, err := b.Build(ctx, nil)
-				if err != nil {
-					return err
+			sources, err := openDataSources(env)
+			if err != nil {
+				kv.Close()
+				return err
+			}
+			closeStores := func() error {
+				closeDataSources(sources)
+				err := auditor.close()
+				if e := kv.Close(); e != nil {
+					return e
 				}
-
-				if i == 0 {
-					for _, method := range r.Methods {
-						results = append(results, method.Meta)
-					}
+				return err
+			}
+			// the whole config is never exposed, it has the passwords and the tokens.
+			configPrefix := env.Config.StringWithDefault("K8_CONFIG_PREFIX", "")
+			if configPrefix == "" {
+				configPrefix = "k8.scripts."
+			}
+			modules := map[string]Module{
+				"kv":     KVModule(kv),
+				"db":     DBModule(sources),
+				"config": ConfigModule(env.Config, configPrefix),
+			}
+			build := func() (*Builder, error) {
+				b, err := newWithAuditor(env, fs, filenames, auditor, modules)
+				if err == nil && tracing.TracerProvider != nil {
+					b.SetTracerProvider(tracing.TracerProvider)
 				}
-				pool <- r
+				return b, err
+			}
+			b, err := build()
+			if err != nil {
+				closeStores()
+				return err
 			}
-
 			state, err := lib.NewState(env.Logger.Named("k8"), lib.Options{})
 			if err != nil {
+				closeStores()
 				return err
 			}
-
-			httpSrv.Engine().Any("/k8/:name", func(c *loong.Context) error {
-				r := <-pool
-				defer func() {
-					pool <- r
-				}()
-
-				args := r.Runtime.NewObject()
-				for k, v := range c.QueryParams() {
-					if len(v) == 1 {
-						args.Set(k, v[0])
-					} else {
-						args.Set(k, v)
-					}
-				}
-				result, err := r.RunMethod(lib.WithState(c.StdContext, state), c.Param("name"), args)
-				if err != nil {
-					return c.ReturnError(err)
-				}
-				return c.ReturnQueryResult(result)
+			err = RegisterHandlers(httpSrv.Engine(), b, ServerOptions{
+				AdminToken:        env.Config.StringWithDefault("K8_ADMIN_TOKEN", ""),
+				DebugPublic:       strings.ToLower(env.Config.StringWithDefault("K8_DEBUG_PUBLIC", "false")) == "true",
+				ConsoleLimit:      intWithDefault(env, "K8_CONSOLE_LIMIT", 100),
+				PoolSize:          100,
+				Auditor:           auditor,
+				State:             state,
+				ReplIdleTimeout:   durationWithDefault(env, "K8_REPL_IDLE_TIMEOUT", 10*time.Minute),
+				ReplEvalTimeout:   durationWithDefault(env, "K8_REPL_EVAL_TIMEOUT", 30*time.Second),
+				SignatureRequired: strings.ToLower(env.Config.StringWithDefault("K8_SIGNATURE_REQUIRED", "false")) == "true",
+				Reload:            build,
 			})
+			if err != nil {
+				closeStores()
+				return err
+			}
+			// the stores and the audit file are shared by the reloaded builders, they are
+			// closed with the application.
+			lc.Append(fx.Hook{
+				OnStop: func(context.Context) error {
+					return closeStores()
+				},
+			})
+			return nil
+		})
+	})
+}
 
-			httpSrv.Engine().POST("/k8/_/run_script", func(c *loong.Context) error {
-				r := <-pool
-				defer func() {
-					pool <- r
-				}()
-
-				data, err := ioutil.ReadAll(c.Request().Body)
-				if err != nil {
-					return c.ReturnError(err)
-				}
+func newAuditor(env *moo.Environment, audit InAudit) *Auditor {
+	sink := audit.Sink
+	var closer io.Closer
+	if sink == nil {
+		filename := env.Config.StringWithDefault("K8_AUDIT_FILE", "")
+		if filename == "" {
+			return nil
+		}
+		fileSink := NewFileAuditSink(filename,
+			intWithDefault(env, "K8_AUDIT_MAX_SIZE", 100),
+			intWithDefault(env, "K8_AUDIT_MAX_BACKUPS", 10),
+			intWithDefault(env, "K8_AUDIT_MAX_AGE", 0))
+		sink, closer = fileSink, fileSink
+	}
+	return &Auditor{
+		Sink:     sink,
+		UserFunc: audit.UserFunc,
+		Logger:   env.Logger.Named("k8.audit"),
+		closer:   closer,
+	}
+}
 
-				tmpR, err := b.BuildString(ctx, r.Runtime, string(data))
-				if err != nil {
-					return c.ReturnError(err)
-				}
+// newKVStore opens the store of k8.kv, the values are kept in the file K8_KV_FILE or in
+// memory if it isn't set. The store is shared by the reloaded builders.
+func newKVStore(env *moo.Environment) (KVStore, error) {
+	filename := env.Config.StringWithDefault("K8_KV_FILE", "")
+	if filename == "" {
+		return NewMemoryKV(), nil
+	}
+	return OpenBoltKV(filename)
+}
 
-				args := tmpR.Runtime.NewObject()
-				for k, v := range c.QueryParams() {
-					if len(v) == 1 {
-						args.Set(k, v[0])
-					} else {
-						args.Set(k, v)
-					}
-				}
+func intWithDefault(env *moo.Environment, key string, value int) int {
+	s := env.Config.StringWithDefault(key, "")
+	if s == "" {
+		return value
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		env.Logger.Warn("invalid integer in the config '" + key + "', use the default value")
+		return value
+	}
+	return i
+}
 
-				result, err := tmpR.RunDefaultMethod(lib.WithState(c.StdContext, state), args)
-				if err != nil {
-					return c.ReturnError(err)
-				}
-				return c.ReturnQueryResult(result)
-			})
+func durationWithDefault(env *moo.Environment, key string, value time.Duration) time.Duration {
+	s := env.Config.StringWithDefault(key, "")
+	if s == "" {
+		return value
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		env.Logger.Warn("invalid duration in the config '" + key + "', use the default value")
+		return value
+	}
+	return d
+}
 
-			httpSrv.Engine().GET("/k8/meta/methods", func(c *loong.Context) error {
-				return c.ReturnQueryResult(results)
-			})
-			return nil
-		})
-	})
+// isAdmin checks the admin token, it is passed by the header or the query parameter because
+// the browsers cannot set the headers of the websocket. All admin operations are disabled if
+// no token is configured.
+func isAdmin(c *loong.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	actual := c.Request().Header.Get("X-K8-Admin-Token")
+	if actual == "" {
+		actual = c.QueryParam("admin_token")
+	}
+	return subtle.ConstantTimeCompare([]byte(actual), []byte(token)) == 1
 }
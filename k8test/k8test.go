@@ -0,0 +1,317 @@
+// Package k8test helps the applications embedding k8 to test their scripts, e.g.
+//
+//	h := k8test.New(t, map[string]string{
+//		"/hello.js": `exports.meta = {id: "hello"};
+//	exports.default = function(args) { return "hello " + args.name; };`,
+//	})
+//	h.AssertResult("hello", map[string]interface{}{"name": "k8"}, "hello k8")
+package k8test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/runner-mei/k8"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type options struct {
+	env            map[string]string
+	compatMode     gojs.CompatibilityMode
+	modules        map[string]k8.Module
+	deterministic  *k8.Deterministic
+	tracerProvider trace.TracerProvider
+}
+
+// An Option changes how the scripts are built.
+type Option func(*options)
+
+// WithEnv sets the environment variables of the scripts, the system environment variables
+// are never included.
+func WithEnv(env map[string]string) Option {
+	return func(o *options) {
+		o.env = env
+	}
+}
+
+// WithCompatibilityMode sets the compatibility mode, it is "base" by default.
+func WithCompatibilityMode(mode gojs.CompatibilityMode) Option {
+	return func(o *options) {
+		o.compatMode = mode
+	}
+}
+
+// WithModule registers the module as k8.<name>.
+func WithModule(name string, module k8.Module) Option {
+	return func(o *options) {
+		if o.modules == nil {
+			o.modules = map[string]k8.Module{}
+		}
+		o.modules[name] = module
+	}
+}
+
+// WithDeterministic runs the methods in the deterministic mode.
+func WithDeterministic(d *k8.Deterministic) Option {
+	return func(o *options) {
+		o.deterministic = d
+	}
+}
+
+// WithTracerProvider records the spans of the methods with the tracer provider, e.g. the
+// provider of a k8.SpanRecorder.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = provider
+	}
+}
+
+// A Harness builds the scripts from memory and invokes their methods, the console
+// output and the logs of the last call are kept.
+type Harness struct {
+	t       testing.TB
+	Builder *k8.Builder
+	Runner  *k8.Runner
+
+	mu      sync.Mutex
+	console *k8.ConsoleCapture
+	logs    bytes.Buffer
+	state   *lib.State
+}
+
+// New compiles and checks the scripts, the keys are the filenames. The test fails at once
+// if any script is broken.
+func New(t testing.TB, scripts map[string]string, opts ...Option) *Harness {
+	t.Helper()
+
+	o := &options{compatMode: gojs.CompatibilityModeBase}
+	for _, opt := range opts {
+		opt(o)
+	}
+	env := map[string]string{}
+	for k, v := range o.env {
+		env[k] = v
+	}
+
+	b, err := k8.NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: o.compatMode.String(),
+		Env:               env,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the metrics of the tests never mix with the default one.
+	b.SetMetrics(k8.NewMetrics())
+	b.SetDeterministic(o.deterministic)
+	if o.tracerProvider != nil {
+		b.SetTracerProvider(o.tracerProvider)
+	}
+	for name, module := range o.modules {
+		b.RegisterModule(name, module)
+	}
+
+	filenames := make([]string, 0, len(scripts))
+	for filename := range scripts {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	// the compile errors are reported by Check with the other problems.
+	for _, filename := range filenames {
+		_ = b.Compile(filename, scripts[filename])
+	}
+	if err := b.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.Build(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Harness{t: t, Builder: b, Runner: r}
+	state, err := lib.NewState(log.New(&lockedWriter{mu: &h.mu, w: &h.logs}), lib.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.state = state
+	return h
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// Call invokes the method, args may be a struct, it is passed as the object encoded by
+// encoding/json.
+func (h *Harness) Call(method string, args interface{}) (interface{}, error) {
+	h.t.Helper()
+
+	arg, err := toJS(args)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	capture := k8.NewConsoleCapture(1000)
+	h.mu.Lock()
+	h.console = capture
+	h.logs.Reset()
+	h.mu.Unlock()
+
+	ctx := k8.WithConsoleCapture(lib.WithState(context.Background(), h.state), capture)
+	return h.Runner.RunMethod(ctx, method, arg)
+}
+
+// Invoke invokes the method and decodes the result into result with encoding/json.
+func (h *Harness) Invoke(method string, args, result interface{}) error {
+	h.t.Helper()
+
+	v, err := h.Call(method, args)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+// MustCall invokes the method, the test fails at once if it returns an error.
+func (h *Harness) MustCall(method string, args interface{}) interface{} {
+	h.t.Helper()
+
+	v, err := h.Call(method, args)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return v
+}
+
+// AssertResult asserts the result of the method, the values are compared after encoding
+// them in JSON, so the types of the numbers don't matter.
+func (h *Harness) AssertResult(method string, args, expected interface{}) bool {
+	h.t.Helper()
+
+	v, err := h.Call(method, args)
+	if !assert.NoError(h.t, err, method) {
+		return false
+	}
+	actualJSON, err := json.Marshal(v)
+	if !assert.NoError(h.t, err, method) {
+		return false
+	}
+	expectedJSON, err := json.Marshal(expected)
+	if !assert.NoError(h.t, err, method) {
+		return false
+	}
+	return assert.JSONEq(h.t, string(expectedJSON), string(actualJSON), method)
+}
+
+// AssertError asserts the method fails with an error containing the text.
+func (h *Harness) AssertError(method string, args interface{}, contains string) bool {
+	h.t.Helper()
+
+	_, err := h.Call(method, args)
+	if !assert.Error(h.t, err, method) {
+		return false
+	}
+	return assert.Contains(h.t, err.Error(), contains, method)
+}
+
+// Console returns the console output of the last call.
+func (h *Harness) Console() []k8.ConsoleEntry {
+	h.mu.Lock()
+	capture := h.console
+	h.mu.Unlock()
+	if capture == nil {
+		return nil
+	}
+	return capture.Entries()
+}
+
+// Logs returns the logs written by the last call, they are JSON lines.
+func (h *Harness) Logs() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.logs.String()
+}
+
+// StartServer starts a throwaway http server exposing the handlers of the scripts, it is
+// closed when the test finishes. The admin endpoints are enabled if opts.AdminToken is set.
+func (h *Harness) StartServer(opts k8.ServerOptions) *httptest.Server {
+	h.t.Helper()
+
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 2
+	}
+	if opts.State == nil {
+		opts.State = h.state
+	}
+	engine := loong.New()
+	if err := k8.RegisterHandlers(engine, h.Builder, opts); err != nil {
+		h.t.Fatal(err)
+	}
+	srv := httptest.NewServer(engine)
+	h.t.Cleanup(srv.Close)
+	return srv
+}
+
+// RunTests runs the test cases exported by the scripts with Builder.Test, each case is
+// reported as a subtest named "<script>/<case>", e.g.
+//
+//	h := k8test.New(t, scripts)
+//	h.RunTests()
+func (h *Harness) RunTests() *k8.TestReport {
+	h.t.Helper()
+
+	report, err := h.Builder.Test(context.Background())
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	for i := range report.Results {
+		result := report.Results[i]
+		name := result.Suite + "/" + result.Name
+		if t, ok := h.t.(*testing.T); ok {
+			t.Run(name, func(t *testing.T) {
+				if !result.Passed() {
+					t.Error(result.Failure)
+				}
+			})
+		} else if !result.Passed() {
+			h.t.Errorf("%s: %s", name, result.Failure)
+		}
+	}
+	return report
+}
+
+// toJS converts the struct to the object decoded from its JSON, the other values are
+// returned as is.
+func toJS(args interface{}) (interface{}, error) {
+	switch args.(type) {
+	case nil, bool, string, int, int64, float64, map[string]interface{}, []interface{}:
+		return args, nil
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
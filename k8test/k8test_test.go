@@ -0,0 +1,95 @@
+package k8test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/runner-mei/k8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHarness(t *testing.T) {
+	h := New(t, map[string]string{
+		"/hello.js": `exports.meta = {id: "hello"};
+exports.default = function(args) {
+	console.info("greeting " + args.name);
+	return {message: __ENV.GREETING + " " + args.name, count: args.count + 1};
+};`,
+		"/fail.js": `exports.meta = {id: "fail"};
+exports.default = function() { throw new Error("boom"); };`,
+	}, WithEnv(map[string]string{"GREETING": "hello"}))
+
+	type args struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	h.AssertResult("hello", args{Name: "k8", Count: 1}, map[string]interface{}{"message": "hello k8", "count": 2})
+	entries := h.Console()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "info", entries[0].Level)
+		assert.Equal(t, "greeting k8", entries[0].Message)
+	}
+	assert.Contains(t, h.Logs(), "greeting k8")
+
+	var result struct {
+		Message string `json:"message"`
+		Count   int    `json:"count"`
+	}
+	require.NoError(t, h.Invoke("hello", args{Name: "go", Count: 2}, &result))
+	assert.Equal(t, "hello go", result.Message)
+	assert.Equal(t, 3, result.Count)
+
+	h.AssertError("fail", nil, "boom")
+	h.AssertError("missing", nil, k8.ErrMethodMissing.Error())
+
+	srv := h.StartServer(k8.ServerOptions{})
+	resp, err := http.Get(srv.URL + "/k8/hello?name=http&count=1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Contains(t, string(body), "hello http")
+}
+
+func TestHarnessRunTests(t *testing.T) {
+	h := New(t, map[string]string{
+		"/hello.js": `exports.meta = {id: "hello"};
+exports.default = function(args) { return "hello " + args.name; };
+exports.tests = [{name: "greets", args: {name: "k8"}, expect: "hello k8"}];`,
+		"/hello.test.js": `describe("hello", function() {
+	it("calls", function() {
+		assert.equal(k8.call("hello", {name: "go"}), "hello go");
+	});
+});`,
+	})
+	report := h.RunTests()
+	assert.Len(t, report.Results, 2)
+	assert.Equal(t, 0, report.Failures())
+}
+
+func TestHarnessSpans(t *testing.T) {
+	recorder := k8.NewSpanRecorder()
+	h := New(t, map[string]string{
+		"/hello.js": `exports.meta = {id: "hello"};
+exports.default = function() {
+	return k8.trace.span("greet", function() {
+		k8.trace.setAttribute("lang", "en");
+		return "hello";
+	});
+};`,
+	}, WithTracerProvider(recorder.TracerProvider()))
+	h.AssertResult("hello", nil, "hello")
+
+	spans := map[string]k8.RecordedSpan{}
+	for _, span := range recorder.Spans() {
+		spans[span.Name] = span
+	}
+	require.Contains(t, spans, "greet")
+	require.Contains(t, spans, "k8.run")
+	assert.Equal(t, spans["k8.run"].SpanID, spans["greet"].ParentID)
+	assert.Equal(t, "en", spans["greet"].Attributes["lang"])
+	assert.Equal(t, "hello", spans["k8.run"].Attributes["k8.method"])
+}
@@ -0,0 +1,330 @@
+package k8
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+	bolt "go.etcd.io/bbolt"
+)
+
+// A KVStore keeps the values shared by the invocations and the runners, the values are
+// JSON. A ttl of zero means the key never expires.
+type KVStore interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	// Incr adds delta to the integer value and returns the new value, a missing key is
+	// zero. The ttl is only applied if the key is created.
+	Incr(key string, delta int64, ttl time.Duration) (int64, error)
+	// CompareAndSwap sets the value only if the current value is old, old is nil if the
+	// key must not exist.
+	CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error)
+	Close() error
+}
+
+func incrValue(key string, value []byte, exists bool, delta int64) (int64, error) {
+	if !exists {
+		return delta, nil
+	}
+	i, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, errors.New("value of '" + key + "' isn't an integer")
+	}
+	return i + delta, nil
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryKV is a KVStore in memory, the values are lost when the process exits.
+type MemoryKV struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+// NewMemoryKV creates an empty MemoryKV.
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{entries: map[string]memoryEntry{}, now: time.Now}
+}
+
+// get returns the entry if it isn't expired, the lock must be held.
+func (kv *MemoryKV) get(key string) (memoryEntry, bool) {
+	entry, ok := kv.entries[key]
+	if ok && !entry.expires.IsZero() && !kv.now().Before(entry.expires) {
+		delete(kv.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, ok
+}
+
+func (kv *MemoryKV) set(key string, value []byte, ttl time.Duration) {
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expires = kv.now().Add(ttl)
+	}
+	kv.entries[key] = entry
+}
+
+func (kv *MemoryKV) Get(key string) ([]byte, bool, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, ok := kv.get(key)
+	return entry.value, ok, nil
+}
+
+func (kv *MemoryKV) Set(key string, value []byte, ttl time.Duration) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.set(key, value, ttl)
+	return nil
+}
+
+func (kv *MemoryKV) Delete(key string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.entries, key)
+	return nil
+}
+
+func (kv *MemoryKV) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, ok := kv.get(key)
+	i, err := incrValue(key, entry.value, ok, delta)
+	if err != nil {
+		return 0, err
+	}
+	value := []byte(strconv.FormatInt(i, 10))
+	if ok {
+		kv.entries[key] = memoryEntry{value: value, expires: entry.expires}
+	} else {
+		kv.set(key, value, ttl)
+	}
+	return i, nil
+}
+
+func (kv *MemoryKV) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, ok := kv.get(key)
+	if ok != (old != nil) || ok && string(entry.value) != string(old) {
+		return false, nil
+	}
+	kv.set(key, value, ttl)
+	return true, nil
+}
+
+func (kv *MemoryKV) Close() error {
+	return nil
+}
+
+//nolint:gochecknoglobals
+var boltBucket = []byte("k8.kv")
+
+// BoltKV is a KVStore in a bbolt file, every value is prefixed with the expiration time
+// in unix nanoseconds, zero means it never expires.
+type BoltKV struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+// OpenBoltKV opens or creates the file.
+func OpenBoltKV(filename string) (*BoltKV, error) {
+	db, err := bolt.Open(filename, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "open kv '"+filename+"'")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltKV{db: db, now: time.Now}, nil
+}
+
+func (kv *BoltKV) encode(value []byte, ttl time.Duration) []byte {
+	data := make([]byte, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(kv.now().Add(ttl).UnixNano()))
+	}
+	copy(data[8:], value)
+	return data
+}
+
+// get returns the value if it isn't expired, the value is copied because it is only valid
+// in the transaction.
+func (kv *BoltKV) get(b *bolt.Bucket, key string) ([]byte, []byte, bool) {
+	data := b.Get([]byte(key))
+	if len(data) < 8 {
+		return nil, nil, false
+	}
+	if expires := int64(binary.BigEndian.Uint64(data)); expires != 0 && kv.now().UnixNano() >= expires {
+		return nil, nil, false
+	}
+	value := append([]byte(nil), data[8:]...)
+	return value, append([]byte(nil), data[:8]...), true
+}
+
+func (kv *BoltKV) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	var ok bool
+	err := kv.db.View(func(tx *bolt.Tx) error {
+		value, _, ok = kv.get(tx.Bucket(boltBucket), key)
+		return nil
+	})
+	return value, ok, err
+}
+
+func (kv *BoltKV) Set(key string, value []byte, ttl time.Duration) error {
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), kv.encode(value, ttl))
+	})
+}
+
+func (kv *BoltKV) Delete(key string) error {
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (kv *BoltKV) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	var i int64
+	err := kv.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		value, expires, ok := kv.get(b, key)
+		var err error
+		i, err = incrValue(key, value, ok, delta)
+		if err != nil {
+			return err
+		}
+		value = []byte(strconv.FormatInt(i, 10))
+		if ok {
+			return b.Put([]byte(key), append(expires, value...))
+		}
+		return b.Put([]byte(key), kv.encode(value, ttl))
+	})
+	return i, err
+}
+
+func (kv *BoltKV) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	swapped := false
+	err := kv.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		current, _, ok := kv.get(b, key)
+		if ok != (old != nil) || ok && string(current) != string(old) {
+			return nil
+		}
+		swapped = true
+		return b.Put([]byte(key), kv.encode(value, ttl))
+	})
+	return swapped, err
+}
+
+func (kv *BoltKV) Close() error {
+	return kv.db.Close()
+}
+
+// kvNamespace returns the namespace of the keys of the running method, it is the id of
+// the method or meta.kv if it is set.
+func kvNamespace(ctx context.Context) string {
+	inv := getInvocation(ctx)
+	if inv == nil {
+		return "_"
+	}
+	if ns, ok := inv.meta["kv"].(string); ok && ns != "" {
+		return ns
+	}
+	return inv.id()
+}
+
+// KVModule exposes the store as k8.kv, the keys are namespaced by the method, e.g.
+//
+//	k8.kv.set("token", {value: "abc"}, 60); // expires in 60 seconds
+//	var token = k8.kv.get("token", null);
+//	k8.kv.incr("hits");
+//	k8.kv.cas("lock", null, "owner");       // only if "lock" doesn't exist
+//	k8.kv.delete("token");
+//
+// It isn't registered by NewBuilder, the store must be shared by all builders of the
+// application, including the reloaded ones, so the caller registers it.
+func KVModule(store KVStore) Module {
+	return ModuleFunc(func(rt *gojs.Runtime) interface{} {
+		key := func(ctx context.Context, call goja.FunctionCall) string {
+			k := call.Argument(0)
+			if goja.IsUndefined(k) || goja.IsNull(k) {
+				panic(rt.NewTypeError("k8.kv: key is missing"))
+			}
+			return kvNamespace(ctx) + "/" + k.String()
+		}
+		ttl := func(v goja.Value) time.Duration {
+			if goja.IsUndefined(v) || goja.IsNull(v) {
+				return 0
+			}
+			return time.Duration(v.ToFloat() * float64(time.Second))
+		}
+		encode := func(v goja.Value) []byte {
+			data, err := json.Marshal(v.Export())
+			if err != nil {
+				panic(rt.NewGoError(err))
+			}
+			return data
+		}
+		check := func(err error) {
+			if err != nil {
+				panic(rt.NewGoError(err))
+			}
+		}
+
+		return map[string]interface{}{
+			"get": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				data, ok, err := store.Get(key(ctx, call))
+				check(err)
+				if !ok {
+					return call.Argument(1)
+				}
+				var v interface{}
+				check(json.Unmarshal(data, &v))
+				return rt.ToValue(v)
+			},
+			"set": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				check(store.Set(key(ctx, call), encode(call.Argument(1)), ttl(call.Argument(2))))
+				return goja.Undefined()
+			},
+			"delete": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				check(store.Delete(key(ctx, call)))
+				return goja.Undefined()
+			},
+			"incr": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				delta := int64(1)
+				if v := call.Argument(1); !goja.IsUndefined(v) && !goja.IsNull(v) {
+					delta = v.ToInteger()
+				}
+				i, err := store.Incr(key(ctx, call), delta, ttl(call.Argument(2)))
+				check(err)
+				return rt.ToValue(i)
+			},
+			"cas": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				var old []byte
+				if v := call.Argument(1); !goja.IsUndefined(v) && !goja.IsNull(v) {
+					old = encode(v)
+				}
+				swapped, err := store.CompareAndSwap(key(ctx, call), old, encode(call.Argument(2)), ttl(call.Argument(3)))
+				check(err)
+				return rt.ToValue(swapped)
+			},
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package k8
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKVStore(t *testing.T, store KVStore, advance func(time.Duration)) {
+	_, ok, err := store.Get("a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, store.Set("a", []byte(`"x"`), 0))
+	v, ok, err := store.Get("a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, `"x"`, string(v))
+
+	i, err := store.Incr("n", 2, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), i)
+	i, err = store.Incr("n", 3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), i)
+	_, err = store.Incr("a", 1, 0)
+	assert.Error(t, err)
+
+	swapped, err := store.CompareAndSwap("c", nil, []byte(`1`), 0)
+	require.NoError(t, err)
+	assert.True(t, swapped)
+	swapped, err = store.CompareAndSwap("c", nil, []byte(`2`), 0)
+	require.NoError(t, err)
+	assert.False(t, swapped)
+	swapped, err = store.CompareAndSwap("c", []byte(`1`), []byte(`2`), 0)
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	require.NoError(t, store.Delete("a"))
+	_, ok, err = store.Get("a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// the ttl of "n" is kept by incr.
+	advance(2 * time.Minute)
+	_, ok, err = store.Get("n")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = store.Get("c")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestMemoryKV(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryKV()
+	store.now = func() time.Time { return now }
+	testKVStore(t, store, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestBoltKV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "kv.db")
+	store, err := OpenBoltKV(filename)
+	require.NoError(t, err)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	testKVStore(t, store, func(d time.Duration) { now = now.Add(d) })
+	require.NoError(t, store.Close())
+
+	// the values survive a restart.
+	store, err = OpenBoltKV(filename)
+	require.NoError(t, err)
+	defer store.Close()
+	v, ok, err := store.Get("c")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, `2`, string(v))
+}
+
+func TestKVModule(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	store := NewMemoryKV()
+	b.RegisterModule("kv", KVModule(store))
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function(args) {
+	if (args.value !== undefined) {
+		k8.kv.set("key", args.value, 60);
+	}
+	return {value: k8.kv.get("key", "none"), hits: k8.kv.incr("hits"), first: k8.kv.cas("lock", null, "a")};
+};`))
+	require.NoError(t, b.Compile("/b.js", `exports.meta = {id: "b", kv: "shared"};
+exports.default = function() { return k8.kv.get("key", "none"); };`))
+	require.NoError(t, b.Check(context.Background()))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	v, err := r.RunMethod(context.Background(), "a", map[string]interface{}{"value": map[string]interface{}{"x": 1}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"value": map[string]interface{}{"x": float64(1)},
+		"hits":  int64(1),
+		"first": true,
+	}, v)
+
+	v, err = r.RunMethod(context.Background(), "a", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), v.(map[string]interface{})["hits"])
+	assert.Equal(t, false, v.(map[string]interface{})["first"])
+
+	// the keys are namespaced by the method.
+	v, err = r.RunMethod(context.Background(), "b", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "none", v)
+	require.NoError(t, store.Set("shared/key", []byte(`"from go"`), 0))
+	v, err = r.RunMethod(context.Background(), "b", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "from go", v)
+}
@@ -0,0 +1,148 @@
+package k8
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+)
+
+// RequestInfo describes the request of an invocation, it is attached to the logs of
+// k8.log.
+type RequestInfo struct {
+	ID   string
+	User string
+}
+
+type requestInfoKey struct{}
+
+func (key *requestInfoKey) String() string {
+	return "requestInfo"
+}
+
+//nolint:gochecknoglobals
+var ctxKeyRequestInfo = &requestInfoKey{}
+
+// WithRequestInfo attaches the request to the context of the invocation.
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, ctxKeyRequestInfo, info)
+}
+
+func getRequestInfo(ctx context.Context) (RequestInfo, bool) {
+	if ctx == nil {
+		return RequestInfo{}, false
+	}
+	info, ok := ctx.Value(ctxKeyRequestInfo).(RequestInfo)
+	return info, ok
+}
+
+// requestInfoOf returns the request, the id is the header X-Request-Id or a random one.
+func requestInfoOf(c *loong.Context, auditor *Auditor) RequestInfo {
+	info := RequestInfo{ID: c.Request().Header.Get("X-Request-Id")}
+	if info.ID == "" {
+		var id [16]byte
+		if _, err := rand.Read(id[:]); err == nil {
+			info.ID = hex.EncodeToString(id[:])
+		}
+	}
+	if auditor != nil && auditor.UserFunc != nil {
+		info.User = auditor.UserFunc(c)
+	}
+	return info
+}
+
+// logLevelOff disables all logs of the method.
+const logLevelOff = log.FatalLevel + 1
+
+//nolint:gochecknoglobals
+var logLevels = map[string]log.Level{
+	"debug": log.DebugLevel,
+	"info":  log.InfoLevel,
+	"warn":  log.WarnLevel,
+	"error": log.ErrorLevel,
+	"off":   logLevelOff,
+}
+
+// methodLogLevel returns the level in meta.log of the running method, it is info by default.
+func methodLogLevel(ctx context.Context) log.Level {
+	if inv := getInvocation(ctx); inv != nil {
+		if s, ok := inv.meta["log"].(string); ok {
+			if level, ok := logLevels[strings.ToLower(s)]; ok {
+				return level
+			}
+		}
+	}
+	return log.InfoLevel
+}
+
+// logModule is exposed as k8.log, the logs are written by the logger of lib.State with
+// the method, the request id and the user, e.g.
+//
+//	k8.log.info("user created", {id: 1, name: "k8"});
+//
+// The logs below the level in meta.log of the method are dropped, e.g. log: "warn", or
+// log: "off" to drop all of them.
+func logModule(rt *gojs.Runtime) interface{} {
+	write := func(level log.Level) func(ctx context.Context, call goja.FunctionCall) goja.Value {
+		return func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			if level < methodLogLevel(ctx) {
+				return goja.Undefined()
+			}
+			state := lib.GetState(ctx)
+			if state == nil || state.Logger == nil {
+				return goja.Undefined()
+			}
+
+			fields := make([]log.Field, 0, 8)
+			if inv := getInvocation(ctx); inv != nil {
+				fields = append(fields, log.String("k8.method", inv.id()))
+			}
+			if info, ok := getRequestInfo(ctx); ok {
+				if info.ID != "" {
+					fields = append(fields, log.String("k8.request_id", info.ID))
+				}
+				if info.User != "" {
+					fields = append(fields, log.String("k8.user", info.User))
+				}
+			}
+			if v := call.Argument(1); !goja.IsUndefined(v) && !goja.IsNull(v) {
+				if values, ok := v.Export().(map[string]interface{}); ok {
+					keys := make([]string, 0, len(values))
+					for k := range values {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						fields = append(fields, log.Any(k, values[k]))
+					}
+				}
+			}
+
+			msg := call.Argument(0).String()
+			switch level {
+			case log.DebugLevel:
+				state.Logger.Debug(msg, fields...)
+			case log.InfoLevel:
+				state.Logger.Info(msg, fields...)
+			case log.WarnLevel:
+				state.Logger.Warn(msg, fields...)
+			default:
+				state.Logger.Error(msg, fields...)
+			}
+			return goja.Undefined()
+		}
+	}
+	return map[string]interface{}{
+		"debug": write(log.DebugLevel),
+		"info":  write(log.InfoLevel),
+		"warn":  write(log.WarnLevel),
+		"error": write(log.ErrorLevel),
+	}
+}
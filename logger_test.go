@@ -0,0 +1,76 @@
+package k8
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/runner-mei/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogModule(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() {
+	k8.log.debug("dropped");
+	k8.log.info("created", {id: 1, name: "k8"});
+};`))
+	require.NoError(t, b.Compile("/quiet.js", `exports.meta = {id: "quiet", log: "error"};
+exports.default = function() {
+	k8.log.warn("dropped");
+	k8.log.error("failed", {code: "E1"});
+};`))
+	require.NoError(t, b.Check(context.Background()))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	state, err := lib.NewState(log.New(&buf), lib.Options{})
+	require.NoError(t, err)
+	ctx := WithRequestInfo(lib.WithState(context.Background(), state), RequestInfo{ID: "r1", User: "admin"})
+
+	_, err = r.RunMethod(ctx, "a", nil)
+	require.NoError(t, err)
+	_, err = r.RunMethod(ctx, "quiet", nil)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2, buf.String())
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, "created", entries[0]["msg"])
+	assert.Equal(t, "a", entries[0]["k8.method"])
+	assert.Equal(t, "r1", entries[0]["k8.request_id"])
+	assert.Equal(t, "admin", entries[0]["k8.user"])
+	assert.Equal(t, float64(1), entries[0]["id"])
+	assert.Equal(t, "k8", entries[0]["name"])
+	assert.Equal(t, "failed", entries[1]["msg"])
+	assert.Equal(t, "quiet", entries[1]["k8.method"])
+	assert.Equal(t, "E1", entries[1]["code"])
+}
+
+func TestCheckLogLevel(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a", log: "loud"};
+exports.default = function() {};`))
+	err = b.Check(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "log must be one of")
+	}
+}
@@ -0,0 +1,299 @@
+package k8
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs/lib"
+)
+
+// The kinds of the invocation errors.
+const (
+	ErrorKindTimeout           = "timeout"
+	ErrorKindScript            = "script_error"
+	ErrorKindMissingMethod     = "missing_method"
+	ErrorKindResourceExhausted = "resource_exhausted"
+	ErrorKindHeapGuard         = "heap_guard"
+)
+
+//nolint:gochecknoglobals
+var (
+	// DefaultBuckets is same as the default buckets of the prometheus client.
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	// DefaultMetrics is used by the builders created by New, it is shared by the builders
+	// of the reloads.
+	DefaultMetrics = NewMetrics()
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type errorKey struct {
+	method string
+	kind   string
+}
+
+// Metrics collects the statistics of the method invocations, the runner pool and the
+// compilation, they are exported in the Prometheus text format. A nil *Metrics is valid
+// and collects nothing.
+type Metrics struct {
+	mu sync.Mutex
+
+	invocations map[string]uint64
+	errors      map[errorKey]uint64
+	latencies   map[string]*histogram
+
+	pool     *Pool
+	poolWait *histogram
+
+	compiles        uint64
+	compileFailures uint64
+	compileDuration *histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		invocations:     map[string]uint64{},
+		errors:          map[errorKey]uint64{},
+		latencies:       map[string]*histogram{},
+		poolWait:        newHistogram(DefaultBuckets),
+		compileDuration: newHistogram(DefaultBuckets),
+	}
+}
+
+func (m *Metrics) observeInvocation(method string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.invocations[method]++
+	h := m.latencies[method]
+	if h == nil {
+		h = newHistogram(DefaultBuckets)
+		m.latencies[method] = h
+	}
+	h.observe(elapsed.Seconds())
+
+	if err != nil {
+		kind := ErrorKindScript
+		cause := errors.Cause(err)
+		if _, ok := cause.(lib.TimeoutError); ok {
+			kind = ErrorKindTimeout
+		} else if cause == ErrResourceExhausted {
+			kind = ErrorKindResourceExhausted
+		} else if cause == ErrHeapGuard {
+			kind = ErrorKindHeapGuard
+		}
+		m.errors[errorKey{method: method, kind: kind}]++
+	}
+}
+
+// observeMissingMethod records a call of a method which doesn't exist, the name is not
+// used as the label because it comes from the client.
+func (m *Metrics) observeMissingMethod() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errors[errorKey{method: "", kind: ErrorKindMissingMethod}]++
+}
+
+func (m *Metrics) observeCompile(elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.compiles++
+	if err != nil {
+		m.compileFailures++
+	}
+	m.compileDuration.observe(elapsed.Seconds())
+}
+
+func (m *Metrics) observePoolWait(elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.poolWait.observe(elapsed.Seconds())
+}
+
+func (m *Metrics) setPool(pool *Pool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pool = pool
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	out := &metricsWriter{w: bufio.NewWriter(w)}
+	if m != nil {
+		m.mu.Lock()
+		m.write(out)
+		m.mu.Unlock()
+	}
+	if err := out.w.Flush(); err != nil {
+		return out.n, err
+	}
+	return out.n, out.err
+}
+
+func (m *Metrics) write(out *metricsWriter) {
+	methods := make([]string, 0, len(m.invocations))
+	for method := range m.invocations {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	out.header("k8_invocations_total", "counter", "Total number of the method invocations.")
+	for _, method := range methods {
+		out.sample("k8_invocations_total", labels("method", method), float64(m.invocations[method]))
+	}
+
+	errorKeys := make([]errorKey, 0, len(m.errors))
+	for key := range m.errors {
+		errorKeys = append(errorKeys, key)
+	}
+	sort.Slice(errorKeys, func(i, j int) bool {
+		if errorKeys[i].method != errorKeys[j].method {
+			return errorKeys[i].method < errorKeys[j].method
+		}
+		return errorKeys[i].kind < errorKeys[j].kind
+	})
+	out.header("k8_invocation_errors_total", "counter", "Total number of the failed method invocations by kind.")
+	for _, key := range errorKeys {
+		out.sample("k8_invocation_errors_total", labels("method", key.method, "kind", key.kind), float64(m.errors[key]))
+	}
+
+	out.header("k8_invocation_duration_seconds", "histogram", "Latency of the method invocations.")
+	for _, method := range methods {
+		out.histogram("k8_invocation_duration_seconds", []string{"method", method}, m.latencies[method])
+	}
+
+	if m.pool != nil {
+		size, idle := m.pool.Size(), m.pool.Idle()
+		out.header("k8_pool_size", "gauge", "Number of the runners in the pool.")
+		out.sample("k8_pool_size", "", float64(size))
+		out.header("k8_pool_idle", "gauge", "Number of the idle runners in the pool.")
+		out.sample("k8_pool_idle", "", float64(idle))
+		out.header("k8_pool_busy", "gauge", "Number of the busy runners in the pool.")
+		out.sample("k8_pool_busy", "", float64(size-idle))
+	}
+	out.header("k8_pool_wait_seconds", "histogram", "Time spent waiting for a runner from the pool.")
+	out.histogram("k8_pool_wait_seconds", nil, m.poolWait)
+
+	out.header("k8_compile_total", "counter", "Total number of the script compilations.")
+	out.sample("k8_compile_total", "", float64(m.compiles))
+	out.header("k8_compile_failures_total", "counter", "Total number of the failed script compilations.")
+	out.sample("k8_compile_failures_total", "", float64(m.compileFailures))
+	out.header("k8_compile_duration_seconds", "histogram", "Time spent compiling the scripts.")
+	out.histogram("k8_compile_duration_seconds", nil, m.compileDuration)
+}
+
+type metricsWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (out *metricsWriter) writeString(s string) {
+	if out.err != nil {
+		return
+	}
+	n, err := out.w.WriteString(s)
+	out.n += int64(n)
+	out.err = err
+}
+
+func (out *metricsWriter) header(name, typ, help string) {
+	out.writeString("# HELP " + name + " " + help + "\n")
+	out.writeString("# TYPE " + name + " " + typ + "\n")
+}
+
+func (out *metricsWriter) sample(name, labels string, value float64) {
+	out.writeString(name + labels + " " + formatFloat(value) + "\n")
+}
+
+func (out *metricsWriter) histogram(name string, kv []string, h *histogram) {
+	for i, upper := range h.buckets {
+		out.sample(name+"_bucket", labels(append(kv, "le", formatFloat(upper))...), float64(h.counts[i]))
+	}
+	out.sample(name+"_bucket", labels(append(kv, "le", "+Inf")...), float64(h.count))
+	out.sample(name+"_sum", labels(kv...), h.sum)
+	out.sample(name+"_count", labels(kv...), float64(h.count))
+}
+
+//nolint:gochecknoglobals
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(kv ...string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(kv[i])
+		sb.WriteString(`="`)
+		sb.WriteString(labelValueEscaper.Replace(kv[i+1]))
+		sb.WriteString(`"`)
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
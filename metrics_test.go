@@ -0,0 +1,83 @@
+package k8
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{})
+	require.NoError(t, err)
+	assert.NotSame(t, DefaultMetrics, b.Metrics(), "only the builders of the service share the default metrics")
+	b.SetMetrics(metrics)
+
+	require.NoError(t, b.Compile("/a.js", `module.exports.meta = {id: 'a'};
+		module.exports.default = function(args) { if (args.fail) { throw new Error("fail"); } return 1; }`))
+	require.NoError(t, b.Compile("/c.js", `module.exports.meta = {id: 'c', version: 'v1.0'};
+		module.exports.default = function(args) { return 1; }`))
+	require.Error(t, b.Compile("/b.js", "\x00"))
+
+	ctx := context.Background()
+	pool := NewPool(2, metrics)
+	for i := 0; i < 2; i++ {
+		r, err := b.Build(ctx, nil)
+		require.NoError(t, err)
+		pool.Add(r)
+	}
+
+	r, err := pool.Get(ctx)
+	require.NoError(t, err)
+	_, err = r.RunMethod(ctx, "a", map[string]interface{}{})
+	require.NoError(t, err)
+	_, err = r.RunMethod(ctx, "a", map[string]interface{}{"fail": true})
+	require.Error(t, err)
+	for _, name := range []string{"c", "c@1.0", "c@v1.0"} {
+		_, err = r.RunMethod(ctx, name, map[string]interface{}{})
+		require.NoError(t, err)
+	}
+	_, err = r.RunMethod(ctx, "notexists", map[string]interface{}{})
+	require.Equal(t, ErrMethodMissing, err)
+
+	var sb strings.Builder
+	_, err = metrics.WriteTo(&sb)
+	require.NoError(t, err)
+	pool.Put(r)
+
+	text := sb.String()
+	for _, line := range []string{
+		`# TYPE k8_invocations_total counter`,
+		`k8_invocations_total{method="a"} 2`,
+		`k8_invocations_total{method="c@v1.0"} 3`,
+		`k8_invocation_errors_total{method="",kind="missing_method"} 1`,
+		`k8_invocation_errors_total{method="a",kind="script_error"} 1`,
+		`# TYPE k8_invocation_duration_seconds histogram`,
+		`k8_invocation_duration_seconds_bucket{method="a",le="+Inf"} 2`,
+		`k8_invocation_duration_seconds_count{method="a"} 2`,
+		`k8_pool_size 2`,
+		`k8_pool_idle 1`,
+		`k8_pool_busy 1`,
+		`k8_pool_wait_seconds_count 1`,
+		`k8_compile_total 3`,
+		`k8_compile_failures_total 1`,
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+	assert.NotContains(t, text, `method="c"`)
+	assert.NotContains(t, text, `method="c@1.0"`)
+
+	t.Run("PoolTimeout", func(t *testing.T) {
+		empty := NewPool(1, nil)
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := empty.Get(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+}
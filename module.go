@@ -0,0 +1,37 @@
+package k8
+
+import (
+	"github.com/runner-mei/gojs"
+)
+
+// A Module is exposed to the scripts as a property of the global object "k8", e.g.
+// k8.trace.
+type Module interface {
+	// Exports returns the value of the module for the runtime. The functions in it may
+	// take a context.Context as the first argument, it is the context of the invocation,
+	// see gojs.Runtime.ToValue.
+	Exports(rt *gojs.Runtime) interface{}
+}
+
+// ModuleFunc is an adapter to allow the use of ordinary functions as a Module.
+type ModuleFunc func(rt *gojs.Runtime) interface{}
+
+func (fn ModuleFunc) Exports(rt *gojs.Runtime) interface{} {
+	return fn(rt)
+}
+
+// RegisterModule exposes the module as k8.<name>, it must be called before Build.
+func (b *Builder) RegisterModule(name string, module Module) {
+	if b.modules == nil {
+		b.modules = map[string]Module{}
+	}
+	b.modules[name] = module
+}
+
+func installModules(rt *gojs.Runtime, modules map[string]Module) {
+	k8 := rt.NewObject()
+	for name, module := range modules {
+		_ = k8.Set(name, rt.ToValue(module.Exports(rt)))
+	}
+	rt.Set("k8", k8)
+}
@@ -0,0 +1,79 @@
+package k8
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// A Pool keeps the runners, a runner is used by one request at a time.
+type Pool struct {
+	// New creates a runner to replace the one which is discarded, the pool shrinks if
+	// it is nil or fails. It is called in background while the requests may build their
+	// own runners, so it must be safe for concurrent use, such as Builder.Build.
+	New func() (*Runner, error)
+
+	runners chan *Runner
+	size    int32
+	metrics *Metrics
+}
+
+// NewPool creates a pool which can hold at most capacity runners.
+func NewPool(capacity int, metrics *Metrics) *Pool {
+	pool := &Pool{
+		runners: make(chan *Runner, capacity),
+		metrics: metrics,
+	}
+	metrics.setPool(pool)
+	return pool
+}
+
+// Add puts a new runner into the pool.
+func (pool *Pool) Add(r *Runner) {
+	atomic.AddInt32(&pool.size, 1)
+	pool.runners <- r
+}
+
+// Get waits for an idle runner until the context is done.
+func (pool *Pool) Get(ctx context.Context) (*Runner, error) {
+	span, ctx := startSpan(ctx, nil, "k8.pool.acquire")
+	defer span.End()
+
+	started := time.Now()
+	select {
+	case r := <-pool.runners:
+		pool.metrics.observePoolWait(time.Since(started))
+		return r, nil
+	case <-ctx.Done():
+		pool.metrics.observePoolWait(time.Since(started))
+		return nil, ctx.Err()
+	}
+}
+
+// Put returns the runner got by Get, the runner which exhausted its budget is discarded
+// and replaced in background.
+func (pool *Pool) Put(r *Runner) {
+	if !r.Exhausted() {
+		pool.runners <- r
+		return
+	}
+	atomic.AddInt32(&pool.size, -1)
+	if pool.New != nil {
+		go func() {
+			r, err := pool.New()
+			if err == nil {
+				pool.Add(r)
+			}
+		}()
+	}
+}
+
+// Size returns the number of the runners in the pool, including the busy ones.
+func (pool *Pool) Size() int {
+	return int(atomic.LoadInt32(&pool.size))
+}
+
+// Idle returns the number of the idle runners.
+func (pool *Pool) Idle() int {
+	return len(pool.runners)
+}
@@ -0,0 +1,187 @@
+package k8
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/loong"
+	"golang.org/x/net/websocket"
+)
+
+// replMessage is the message exchanged over the websocket of the REPL.
+//
+// Client sends:
+//
+//	{"type": "eval", "code": "var a = 1"}
+//	{"type": "complete", "prefix": "JSON.str"}
+//
+// Server sends:
+//
+//	{"type": "result", "value": "1"}
+//	{"type": "error", "error": "ReferenceError: b is not defined at <eval>:1:1(0)"}
+//	{"type": "console", "level": "info", "message": "abc"}
+//	{"type": "completions", "items": ["JSON.stringify"]}
+type replMessage struct {
+	Type    string   `json:"type"`
+	Code    string   `json:"code,omitempty"`
+	Prefix  string   `json:"prefix,omitempty"`
+	Value   string   `json:"value,omitempty"`
+	Level   string   `json:"level,omitempty"`
+	Message string   `json:"message,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Items   []string `json:"items,omitempty"`
+}
+
+type replSession struct {
+	c           *loong.Context
+	auditor     *Auditor
+	ctx         context.Context
+	runner      *Runner
+	conn        *websocket.Conn
+	idleTimeout time.Duration
+	evalTimeout time.Duration
+}
+
+// serveREPL evaluates lines on a dedicated runner until the client closes the connection or
+// the session is idle for idleTimeout, every line is recorded by the auditor.
+func serveREPL(c *loong.Context, ctx context.Context, r *Runner, auditor *Auditor, idleTimeout, evalTimeout time.Duration) error {
+	srv := websocket.Server{
+		// The origin is not checked, only admin can reach here.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+
+			session := &replSession{
+				c:           c,
+				auditor:     auditor,
+				ctx:         ctx,
+				runner:      r,
+				conn:        conn,
+				idleTimeout: idleTimeout,
+				evalTimeout: evalTimeout,
+			}
+			session.run()
+		},
+	}
+	srv.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+func (s *replSession) run() {
+	s.runner.Runtime.Set("console", newConsole(s.runner.Runtime, func(_ context.Context, level, msg string) {
+		s.send(&replMessage{Type: "console", Level: level, Message: msg})
+	}))
+
+	for {
+		if s.idleTimeout > 0 {
+			if err := s.conn.SetReadDeadline(time.Now().Add(s.idleTimeout)); err != nil {
+				return
+			}
+		}
+
+		var msg replMessage
+		if err := websocket.JSON.Receive(s.conn, &msg); err != nil {
+			// the client is closed or the session is idle too long.
+			return
+		}
+
+		switch msg.Type {
+		case "eval":
+			value, err := s.eval(msg.Code)
+			if err != nil {
+				s.send(&replMessage{Type: "error", Error: err.Error()})
+			} else {
+				s.send(&replMessage{Type: "result", Value: formatValue(value)})
+			}
+		case "complete":
+			s.send(&replMessage{Type: "completions", Items: s.complete(msg.Prefix)})
+		default:
+			s.send(&replMessage{Type: "error", Error: "unknown message type '" + msg.Type + "'"})
+		}
+	}
+}
+
+func (s *replSession) send(msg *replMessage) {
+	_ = websocket.JSON.Send(s.conn, msg)
+}
+
+func (s *replSession) eval(code string) (value goja.Value, err error) {
+	started := time.Now()
+	defer func() {
+		s.auditor.Record(s.c, "_/repl", nil, nil, code, started, err)
+	}()
+
+	rt := s.runner.Runtime
+	if s.evalTimeout > 0 {
+		timer := time.AfterFunc(s.evalTimeout, func() {
+			rt.Interrupt(errInterrupt)
+		})
+		defer func() {
+			timer.Stop()
+			rt.ClearInterrupt()
+		}()
+	}
+	return rt.RunString(s.ctx, code)
+}
+
+// complete returns the names starting with prefix, prefix may be a property path such as
+// "JSON.str", the properties of the object and its prototypes are returned.
+func (s *replSession) complete(prefix string) []string {
+	rt := s.runner.Runtime
+
+	obj := rt.GlobalObject()
+	base, partial := "", prefix
+	if idx := strings.LastIndexByte(prefix, '.'); idx >= 0 {
+		base, partial = prefix[:idx+1], prefix[idx+1:]
+		for _, name := range strings.Split(prefix[:idx], ".") {
+			v := obj.Get(name)
+			if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+				return nil
+			}
+			obj = v.ToObject(rt.Runtime)
+		}
+	}
+
+	objectCtor := rt.Get("Object").ToObject(rt.Runtime)
+	getOwnPropertyNames, ok := goja.AssertFunction(objectCtor.Get("getOwnPropertyNames"))
+	if !ok {
+		return nil
+	}
+	getPrototypeOf, ok := goja.AssertFunction(objectCtor.Get("getPrototypeOf"))
+	if !ok {
+		return nil
+	}
+
+	seen := map[string]struct{}{}
+	var items []string
+	for current := goja.Value(obj); current != nil && !goja.IsNull(current); {
+		names, err := getOwnPropertyNames(goja.Undefined(), current)
+		if err != nil {
+			break
+		}
+		if list, ok := names.Export().([]interface{}); ok {
+			for _, nm := range list {
+				name, _ := nm.(string)
+				if !strings.HasPrefix(name, partial) {
+					continue
+				}
+				if _, exists := seen[name]; exists {
+					continue
+				}
+				seen[name] = struct{}{}
+				items = append(items, base+name)
+			}
+		}
+
+		current, err = getPrototypeOf(goja.Undefined(), current)
+		if err != nil {
+			break
+		}
+	}
+	sort.Strings(items)
+	return items
+}
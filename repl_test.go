@@ -0,0 +1,62 @@
+package k8
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestREPL(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	r, err := b.BuildString(ctx, nil, `module.exports.default = function() {};`)
+	require.NoError(t, err)
+
+	var records []*AuditRecord
+	auditor := &Auditor{Sink: AuditSinkFunc(func(record *AuditRecord) error {
+		records = append(records, record)
+		return nil
+	})}
+	session := &replSession{ctx: ctx, runner: r, auditor: auditor}
+
+	t.Run("KeepVariables", func(t *testing.T) {
+		_, err := session.eval(`var abc = {a: 1, b: "x"};`)
+		require.NoError(t, err)
+		v, err := session.eval(`abc`)
+		require.NoError(t, err)
+		assert.Equal(t, "{\n  \"a\": 1,\n  \"b\": \"x\"\n}", formatValue(v))
+
+		v, err = session.eval(`abc.b`)
+		require.NoError(t, err)
+		assert.Equal(t, `"x"`, formatValue(v))
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		records = nil
+		_, err := session.eval(`1 + 1`)
+		require.NoError(t, err)
+		_, err = session.eval(`notexists`)
+		require.Error(t, err)
+
+		require.Len(t, records, 2)
+		assert.Equal(t, "_/repl", records[0].Method)
+		assert.Equal(t, "1 + 1", records[0].Code)
+		// the SHA-256 of "1 + 1".
+		assert.Equal(t, "72fce59447a01f488b1169d2d742679cfe306a89772d67fef018bcfe95431f68", records[0].CodeHash)
+		assert.Equal(t, AuditSuccess, records[0].Outcome)
+		assert.Equal(t, AuditFailure, records[1].Outcome)
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		assert.Equal(t, []string{"abc"}, session.complete("ab"))
+		assert.Equal(t, []string{"JSON.stringify"}, session.complete("JSON.s"))
+		assert.Equal(t, []string{"abc.a"}, session.complete("abc.a"))
+		assert.Contains(t, session.complete("abc.has"), "abc.hasOwnProperty")
+		assert.Nil(t, session.complete("notexists.a"))
+	})
+}
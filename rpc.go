@@ -0,0 +1,239 @@
+package k8
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/runner-mei/loong"
+)
+
+// JSON-RPC 2.0 error codes, see https://www.jsonrpc.org/specification#error_object
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     json.RawMessage
+	Result interface{}
+	Error  *rpcError
+}
+
+// MarshalJSON follows the specification: result is required on success (even if it
+// is null) and must not exist on error.
+func (resp *rpcResponse) MarshalJSON() ([]byte, error) {
+	id := resp.ID
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	if resp.Error != nil {
+		return json.Marshal(struct {
+			Version string          `json:"jsonrpc"`
+			Error   *rpcError       `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{"2.0", resp.Error, id})
+	}
+	return json.Marshal(struct {
+		Version string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{"2.0", resp.Result, id})
+}
+
+// rpcObserver is notified after each call is executed, args is a copy taken before the
+// call.
+type rpcObserver func(method string, args interface{}, started time.Time, err error)
+
+func serveRPC(c *loong.Context, ctx context.Context, r *Runner, auditor *Auditor) error {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(c.Request().Body); err != nil {
+		return c.ReturnError(err)
+	}
+
+	result := r.runRPC(ctx, buf.Bytes(), func(method string, args interface{}, started time.Time, err error) {
+		auditor.Record(c, method, r.methodMeta(method), args, "", started, err)
+	})
+	if result == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// runRPC executes a single or a batch request, it returns nil when there is nothing
+// to respond, e.g. all calls are notifications.
+func (runner *Runner) runRPC(ctx context.Context, body []byte, observe rpcObserver) interface{} {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		resp := runner.runRPCCall(ctx, body, observe)
+		if resp == nil {
+			return nil
+		}
+		return resp
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return newRPCError(nil, rpcParseError, err.Error(), nil)
+	}
+	if len(batch) == 0 {
+		return newRPCError(nil, rpcInvalidRequest, "batch is empty", nil)
+	}
+
+	results := make([]*rpcResponse, 0, len(batch))
+	for _, call := range batch {
+		if resp := runner.runRPCCall(ctx, call, observe); resp != nil {
+			results = append(results, resp)
+		}
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return results
+}
+
+func (runner *Runner) runRPCCall(ctx context.Context, data []byte, observe rpcObserver) *rpcResponse {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return newRPCError(nil, rpcParseError, err.Error(), nil)
+		}
+		return newRPCError(nil, rpcInvalidRequest, "request must be an object", nil)
+	}
+
+	// A request without id is a notification, it has no response.
+	id, hasID := fields["id"]
+	if hasID && !isValidRPCID(id) {
+		return newRPCError(nil, rpcInvalidRequest, "id must be a string, number or null", nil)
+	}
+
+	var version, method string
+	if err := json.Unmarshal(fields["jsonrpc"], &version); err != nil || version != "2.0" {
+		return newRPCError(id, rpcInvalidRequest, "jsonrpc must be exactly \"2.0\"", nil)
+	}
+	if err := json.Unmarshal(fields["method"], &method); err != nil || method == "" {
+		return newRPCError(id, rpcInvalidRequest, "method must be a string", nil)
+	}
+
+	args, err := parseRPCParams(fields["params"])
+	if err != nil {
+		if !hasID {
+			return nil
+		}
+		return newRPCError(id, rpcInvalidParams, err.Error(), nil)
+	}
+
+	var recorded interface{}
+	if observe != nil {
+		recorded = snapshotArgs(args)
+	}
+	started := time.Now()
+	result, err := runner.RunMethod(ctx, method, args)
+	if observe != nil {
+		observe(method, recorded, started, err)
+	}
+	if !hasID {
+		return nil
+	}
+	if err != nil {
+		if errors.Cause(err) == ErrMethodMissing {
+			return newRPCError(id, rpcMethodNotFound, "method '"+method+"' is missing", nil)
+		}
+		return newRPCError(id, rpcInternalError, err.Error(), rpcErrorData(err))
+	}
+	return &rpcResponse{ID: id, Result: result}
+}
+
+// parseRPCParams converts params to the argument of the method. A method takes only one
+// argument, so params must be an object or an array with at most one element.
+func parseRPCParams(data json.RawMessage) (interface{}, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return map[string]interface{}{}, nil
+	}
+
+	switch data[0] {
+	case '{':
+		var args map[string]interface{}
+		if err := json.Unmarshal(data, &args); err != nil {
+			return nil, err
+		}
+		return args, nil
+	case '[':
+		var args []interface{}
+		if err := json.Unmarshal(data, &args); err != nil {
+			return nil, err
+		}
+		switch len(args) {
+		case 0:
+			return map[string]interface{}{}, nil
+		case 1:
+			return args[0], nil
+		}
+		return nil, errors.New("params must be an object or an array with at most one element")
+	}
+	return nil, errors.New("params must be an object or an array")
+}
+
+func isValidRPCID(id json.RawMessage) bool {
+	id = bytes.TrimSpace(id)
+	if len(id) == 0 {
+		return false
+	}
+	switch id[0] {
+	case '{', '[', 't', 'f':
+		return false
+	}
+	return true
+}
+
+// rpcErrorData converts the value thrown by the script to the data field of the error.
+func rpcErrorData(err error) interface{} {
+	switch e := errors.Cause(err).(type) {
+	case *goja.Exception:
+		data := map[string]interface{}{
+			"message": e.Error(),
+		}
+		if v := e.Value(); v != nil {
+			if fields, ok := v.Export().(map[string]interface{}); ok {
+				for k, v := range fields {
+					data[k] = v
+				}
+			} else if !goja.IsUndefined(v) && !goja.IsNull(v) {
+				data["value"] = v.Export()
+			}
+		}
+		return data
+	case lib.TimeoutError:
+		return map[string]interface{}{
+			"message": e.Error(),
+			"timeout": e.Place(),
+		}
+	}
+	return nil
+}
+
+func newRPCError(id json.RawMessage, code int, msg string, data interface{}) *rpcResponse {
+	return &rpcResponse{
+		ID: id,
+		Error: &rpcError{
+			Code:    code,
+			Message: msg,
+			Data:    data,
+		},
+	}
+}
@@ -0,0 +1,85 @@
+package k8
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunRPC(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/add.js", `module.exports.meta = {id: 'add'};
+		module.exports.default = function(args) { return args.a + args.b; }`))
+	require.NoError(t, b.Compile("/fail.js", `module.exports.meta = {id: 'fail'};
+		module.exports.default = function(args) { throw {code: 12, reason: "bad"}; }`))
+
+	ctx := context.Background()
+	r, err := b.Build(ctx, nil)
+	require.NoError(t, err)
+
+	run := func(body string) string {
+		result := r.runRPC(ctx, []byte(body), nil)
+		if result == nil {
+			return ""
+		}
+		bs, err := json.Marshal(result)
+		require.NoError(t, err)
+		return string(bs)
+	}
+
+	testCases := []struct {
+		name   string
+		body   string
+		result string
+	}{
+		{"Call", `{"jsonrpc": "2.0", "method": "add", "params": {"a": 1, "b": 2}, "id": 1}`,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{"PositionalParams", `{"jsonrpc": "2.0", "method": "add", "params": [{"a": 1, "b": 3}], "id": "a"}`,
+			`{"jsonrpc":"2.0","result":4,"id":"a"}`},
+		{"NullID", `{"jsonrpc": "2.0", "method": "add", "params": {"a": 1, "b": 2}, "id": null}`,
+			`{"jsonrpc":"2.0","result":3,"id":null}`},
+		{"Notification", `{"jsonrpc": "2.0", "method": "add", "params": {"a": 1, "b": 2}}`, ``},
+		{"MethodNotFound", `{"jsonrpc": "2.0", "method": "abc", "id": 2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method 'abc' is missing"},"id":2}`},
+		{"InvalidParams", `{"jsonrpc": "2.0", "method": "add", "params": [1, 2], "id": 3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"params must be an object or an array with at most one element"},"id":3}`},
+		{"InvalidRequest", `{"jsonrpc": "1.0", "method": "add", "id": 4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"jsonrpc must be exactly \"2.0\""},"id":4}`},
+		{"ParseError", `{"jsonrpc": "2.0", "method": "add", "id": 4`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"unexpected end of JSON input"},"id":null}`},
+		{"EmptyBatch", `[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"batch is empty"},"id":null}`},
+		{"Batch", `[{"jsonrpc": "2.0", "method": "add", "params": {"a": 1, "b": 2}, "id": 1},
+			{"jsonrpc": "2.0", "method": "add", "params": {"a": 1, "b": 2}},
+			{"jsonrpc": "2.0", "method": "add", "params": {"a": 2, "b": 2}, "id": 2},
+			1]`,
+			`[{"jsonrpc":"2.0","result":3,"id":1},{"jsonrpc":"2.0","result":4,"id":2},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"request must be an object"},"id":null}]`},
+		{"BatchNotifications", `[{"jsonrpc": "2.0", "method": "add"}, {"jsonrpc": "2.0", "method": "fail"}]`, ``},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.result, run(tc.body))
+		})
+	}
+
+	t.Run("ScriptError", func(t *testing.T) {
+		var resp struct {
+			Error rpcError `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(run(`{"jsonrpc": "2.0", "method": "fail", "id": 5}`)), &resp))
+		assert.Equal(t, rpcInternalError, resp.Error.Code)
+		data, ok := resp.Error.Data.(map[string]interface{})
+		if assert.True(t, ok) {
+			assert.Equal(t, float64(12), data["code"])
+			assert.Equal(t, "bad", data["reason"])
+		}
+	})
+}
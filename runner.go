@@ -2,17 +2,25 @@ package k8
 
 import (
 	"context"
+	"fmt"
 	"net/http/cookiejar"
+	"strings"
 	"time"
 
 	"github.com/dop251/goja"
+	"github.com/pkg/errors"
 	"github.com/runner-mei/gojs"
 	"github.com/runner-mei/gojs/lib"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Method struct {
 	Meta   map[string]interface{}
 	Method goja.Callable
+	// Capabilities restrict the resources accessed by the method, it is nil if
+	// nothing is restricted.
+	Capabilities *Capabilities
 }
 
 // A Runner is a self-contained instance of a Bundle.
@@ -21,6 +29,20 @@ type Runner struct {
 	Runtime        *gojs.Runtime
 	Default        goja.Callable
 	Methods        map[string]Method
+	Metrics        *Metrics
+	Tracer         trace.Tracer
+	Budget         Budget
+	Deterministic  *Deterministic
+	Cassette       *Cassette
+
+	sourceMaps sourceMaps
+	exhausted  bool
+}
+
+// Exhausted returns true if an invocation exceeded the budget or was interrupted by the
+// heap guard, the runner must not be used again.
+func (runner *Runner) Exhausted() bool {
+	return runner.exhausted
 }
 
 // Runs an exported function in its own temporary VU, optionally with an argument. Execution is
@@ -46,44 +68,223 @@ func (runner *Runner) RunDefaultMethod(ctx context.Context, arg interface{}) (in
 }
 
 func (runner *Runner) RunMethod(ctx context.Context, name string, arg interface{}) (interface{}, error) {
-	fn, ok := runner.Methods[name]
+	fn, ok := runner.lookupMethod(name)
 	if !ok {
+		runner.Metrics.observeMissingMethod()
 		return nil, ErrMethodMissing
 	}
-	return runner.RunFn(ctx /*group, */, name, fn.Method, runner.Runtime.ToValue(arg))
+	inv := &invocation{method: name, meta: fn.Meta}
+	defer inv.done()
+	ctx = withInvocation(ctx, inv)
+	if fn.Capabilities != nil {
+		ctx = withSandbox(ctx, name, fn.Capabilities)
+	}
+	// the aliases of a method, e.g. "a", "a@2" and "a@v2", are run with the key of the
+	// method, so they are counted as one method in the metrics.
+	return runner.RunFn(ctx /*group, */, inv.key(), fn.Method, runner.Runtime.ToValue(arg))
+}
+
+// An invocation is the method being run, it is attached to the context by RunMethod.
+type invocation struct {
+	method  string
+	meta    map[string]interface{}
+	cleanup []func()
+}
+
+// onDone registers fn to release the resources left open by the method, such as the
+// cursors which aren't closed by the script.
+func (inv *invocation) onDone(fn func()) {
+	inv.cleanup = append(inv.cleanup, fn)
+}
+
+func (inv *invocation) done() {
+	for i := len(inv.cleanup) - 1; i >= 0; i-- {
+		inv.cleanup[i]()
+	}
+	inv.cleanup = nil
+}
+
+// id returns the id of the method without the version.
+func (inv *invocation) id() string {
+	if id, ok := inv.meta["id"]; ok && id != nil {
+		return fmt.Sprint(id)
+	}
+	if idx := strings.LastIndexByte(inv.method, '@'); idx >= 0 {
+		return inv.method[:idx]
+	}
+	return inv.method
+}
+
+// key returns the key of the method in Runner.Methods, it is "id@version" if the method
+// is versioned.
+func (inv *invocation) key() string {
+	return methodKey(inv.id(), inv.meta)
+}
+
+type invocationKey struct{}
+
+func (key *invocationKey) String() string {
+	return "invocation"
+}
+
+//nolint:gochecknoglobals
+var ctxKeyInvocation = &invocationKey{}
+
+func withInvocation(ctx context.Context, inv *invocation) context.Context {
+	return context.WithValue(ctx, ctxKeyInvocation, inv)
+}
+
+func getInvocation(ctx context.Context) *invocation {
+	if ctx == nil {
+		return nil
+	}
+	v := ctx.Value(ctxKeyInvocation)
+	if v == nil {
+		return nil
+	}
+	return v.(*invocation)
+}
+
+// methodMeta returns the meta of the method, it is nil if the method doesn't exist.
+func (runner *Runner) methodMeta(name string) map[string]interface{} {
+	method, _ := runner.lookupMethod(name)
+	return method.Meta
+}
+
+// installCall exposes k8.call(name, arg), it calls another method of the runner in the
+// context of the caller, so the method runs within the budget of the caller, but with the
+// capabilities of the called method.
+func (runner *Runner) installCall() {
+	rt := runner.Runtime
+	k8 := rt.Get("k8")
+	if k8 == nil || goja.IsUndefined(k8) || goja.IsNull(k8) {
+		return
+	}
+	_ = k8.ToObject(rt.Runtime).Set("call", rt.ToValue(func(ctx context.Context, call goja.FunctionCall) goja.Value {
+		name := call.Argument(0).String()
+		if mocks := getTestMocks(ctx); mocks != nil {
+			if v, ok := mocks.call(rt, name, call.Argument(1)); ok {
+				return v
+			}
+		}
+		method, ok := runner.lookupMethod(name)
+		if !ok {
+			panic(rt.NewGoError(errors.Wrap(ErrMethodMissing, "k8.call: '"+name+"'")))
+		}
+		rt.SetContext(calleeContext(ctx, name, method.Capabilities))
+		defer rt.SetContext(ctx)
+		v, err := method.Method(goja.Undefined(), call.Argument(1))
+		return throwIfError(rt, v, err)
+	}))
+}
+
+// calleeContext returns the context of the method called by k8.call, the sandbox of the
+// caller is replaced with the one of the callee.
+func calleeContext(ctx context.Context, name string, caps *Capabilities) context.Context {
+	ctx = withSandbox(ctx, name, caps)
+	if old := lib.GetState(ctx); old != nil {
+		state := &lib.State{}
+		*state = *old
+		base := old.Transport
+		if t, ok := base.(*sandboxTransport); ok {
+			base = t.base
+		}
+		if sb := getSandbox(ctx); sb != nil && sb.caps.restrictsHTTP() {
+			base = &sandboxTransport{base: base, sandbox: sb}
+		}
+		state.Transport = base
+		ctx = lib.WithState(ctx, state)
+	}
+	return ctx
 }
 
 func (runner *Runner) RunFn(
 	ctx context.Context, fnname string, fn goja.Callable, args ...goja.Value,
 ) (interface{}, error) {
-	if runner.NoCookiesReset == nil || !*runner.NoCookiesReset {
-		cookieJar, err := cookiejar.New(nil)
-		if err != nil {
-			return nil, err
-		}
+	span, ctx := startSpan(ctx, runner.Tracer, "k8.run")
+	defer span.End()
+	span.SetAttributes(attribute.String("k8.method", fnname))
+	scope := &traceScope{tracer: span.TracerProvider().Tracer(instrumentationName), spans: []trace.Span{span}}
+	ctx = withTraceScope(ctx, scope)
 
-		old := lib.GetState(ctx)
-		if old != nil {
-			state := &lib.State{}
-			*state = *old
+	sb := getSandbox(ctx)
+	old := lib.GetState(ctx)
+	if old == nil && runner.Cassette != nil {
+		// the requests are recorded or replayed even if the caller has no http client.
+		old = &lib.State{}
+	}
+	if old != nil {
+		state := &lib.State{}
+		*state = *old
+		if runner.NoCookiesReset == nil || !*runner.NoCookiesReset {
+			cookieJar, err := cookiejar.New(nil)
+			if err != nil {
+				return nil, err
+			}
 			state.CookieJar = cookieJar
-			ctx = lib.WithState(ctx, state)
 		}
+		base := old.Transport
+		if runner.Cassette != nil {
+			base = &cassetteTransport{base: base, cassette: runner.Cassette}
+		}
+		state.Transport = &tracingTransport{base: base, scope: scope}
+		if sb != nil && sb.caps.restrictsHTTP() {
+			state.Transport = &sandboxTransport{base: state.Transport, sandbox: sb}
+		}
+		ctx = lib.WithState(ctx, state)
+	}
+	if d := getDeterministic(ctx); d != nil || runner.Deterministic != nil {
+		if d == nil {
+			d = runner.Deterministic
+		}
+		defer freeze(runner.Runtime, d)()
 	}
 	runner.Runtime.SetContext(ctx)
+	started := time.Now()
+	watcher := runner.watchHeap()
 	v, err := fn(goja.Undefined(), args...) // Actually run the JS script
+	if guarded := watcher.stop(); guarded != nil {
+		err = guarded
+	} else if err != nil {
+		err = runner.sourceMaps.rewrite(runner.timeoutError(ctx, fnname, v, err))
+	}
+	var result interface{}
+	if err == nil {
+		result = v.Export()
+		err = runner.checkResult(fnname, result)
+	}
+	if cause := errors.Cause(err); cause == ErrResourceExhausted || cause == ErrHeapGuard {
+		runner.exhausted = true
+	}
+	if runner.Cassette != nil {
+		if saveErr := runner.Cassette.Save(); saveErr != nil && err == nil {
+			err = errors.Wrap(saveErr, "save cassette")
+		}
+	}
+	runner.Metrics.observeInvocation(fnname, time.Since(started), err)
 	if err != nil {
-		// deadline is reached so we have timeouted but this might've not been registered correctly
-		if deadline, ok := ctx.Deadline(); ok && time.Now().After(deadline) {
-			// we could have an error that is not errInterrupt in which case we should return it instead
-			if err, ok := err.(*goja.InterruptedError); ok && v != nil && err.Value() != errInterrupt {
-				return v, err
+		setSpanError(span, err)
+		return nil, err
+	}
+	return result, nil
+}
+
+func (runner *Runner) timeoutError(ctx context.Context, fnname string, v goja.Value, err error) error {
+	// deadline is reached so we have timeouted but this might've not been registered correctly
+	if deadline, ok := ctx.Deadline(); ok && time.Now().After(deadline) {
+		// we could have an error that is not errInterrupt in which case we should return it instead
+		if err, ok := err.(*goja.InterruptedError); ok && v != nil && err.Value() != errInterrupt {
+			return err
+		}
+		// otherwise we have timeouted, the stack of the script is kept in the message
+		timeout := lib.NewTimeoutError(fnname, 0)
+		if e, ok := err.(*goja.InterruptedError); ok {
+			if stack := strings.TrimRight(e.Exception.String(), "\n"); stack != "" {
+				return &scriptError{cause: timeout, msg: timeout.Error() + "\n" + stack}
 			}
-			// otherwise we have timeouted
-			return v, lib.NewTimeoutError(fnname, 0)
 		}
-
-		return nil, err
+		return timeout
 	}
-	return v.Export(), nil
+
+	return err
 }
@@ -0,0 +1,288 @@
+package k8
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+)
+
+// Capabilities are the resources which a method can access, they are declared in the
+// meta description, e.g.
+//
+//	exports.meta = {
+//	  id: "report",
+//	  env: ["REPORT_TOKEN", "REPORT_*"],
+//	  http: ["*.internal", "api.example.com"],
+//	  fs: ["/reports"]
+//	};
+//
+// A nil list means the access isn't restricted, and an empty list denies all access.
+// The restrictions are applied while the method is running, including the methods called
+// by k8.call which run with their own capabilities, and the environment variables read by
+// the code at the top level of the script are checked when it is loaded.
+type Capabilities struct {
+	Env  []string
+	HTTP []string
+	FS   []string
+
+	// limit is the capabilities of the bundle of the method, the access must be allowed
+	// by both.
+	limit *Capabilities
+}
+
+// capabilitiesOf returns the capabilities declared in the meta description, the resources
+// which are not declared are denied if strict is true, it returns nil if nothing is
+// restricted.
+func capabilitiesOf(meta map[string]interface{}, strict bool) *Capabilities {
+	caps := &Capabilities{
+		Env:  capabilityList(meta, "env", strict),
+		HTTP: capabilityList(meta, "http", strict),
+		FS:   capabilityList(meta, "fs", strict),
+	}
+	if caps.Env == nil && caps.HTTP == nil && caps.FS == nil {
+		return nil
+	}
+	return caps
+}
+
+func capabilityList(meta map[string]interface{}, key string, strict bool) []string {
+	switch v := meta[key].(type) {
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	case []string:
+		return v
+	case string:
+		return []string{v}
+	}
+	if strict {
+		return []string{}
+	}
+	return nil
+}
+
+// withLimit returns the capabilities restricted by the limit too.
+func (caps *Capabilities) withLimit(limit *Capabilities) *Capabilities {
+	if limit == nil {
+		return caps
+	}
+	restricted := &Capabilities{limit: limit}
+	if caps != nil {
+		restricted.Env, restricted.HTTP, restricted.FS = caps.Env, caps.HTTP, caps.FS
+	}
+	return restricted
+}
+
+// restrictsHTTP returns true if some hosts cannot be requested.
+func (caps *Capabilities) restrictsHTTP() bool {
+	return caps != nil && (caps.HTTP != nil || caps.limit.restrictsHTTP())
+}
+
+// AllowEnv returns true if the environment variable can be read, a name ending with "*"
+// is a prefix.
+func (caps *Capabilities) AllowEnv(name string) bool {
+	if caps == nil {
+		return true
+	}
+	if !caps.limit.AllowEnv(name) {
+		return false
+	}
+	if caps.Env == nil {
+		return true
+	}
+	for _, pattern := range caps.Env {
+		if pattern == name ||
+			strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowHost returns true if the host can be requested, "*.internal" matches all
+// subdomains of "internal", and a pattern with a port only matches the port.
+func (caps *Capabilities) AllowHost(host string) bool {
+	if caps == nil {
+		return true
+	}
+	if !caps.limit.AllowHost(host) {
+		return false
+	}
+	if caps.HTTP == nil {
+		return true
+	}
+	host = strings.ToLower(host)
+	hostname := host
+	if idx := strings.LastIndexByte(host, ':'); idx >= 0 && !strings.HasSuffix(host, "]") {
+		hostname = host[:idx]
+	}
+	for _, pattern := range caps.HTTP {
+		pattern = strings.ToLower(pattern)
+		target := hostname
+		if strings.Contains(pattern, ":") {
+			target = host
+		}
+		switch {
+		case pattern == "*", pattern == target:
+			return true
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(target, pattern[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// AllowPath returns true if the file is in one of the declared directories.
+func (caps *Capabilities) AllowPath(name string) bool {
+	if caps == nil {
+		return true
+	}
+	if !caps.limit.AllowPath(name) {
+		return false
+	}
+	if caps.FS == nil {
+		return true
+	}
+	name = path.Clean("/" + name)
+	for _, dir := range caps.FS {
+		dir = path.Clean("/" + dir)
+		if dir == "/" || name == dir || strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// A PermissionError is returned when a method accesses a resource which isn't declared
+// in its meta description.
+type PermissionError struct {
+	Method   string
+	Kind     string
+	Resource string
+}
+
+func (e *PermissionError) Error() string {
+	return "permission denied: method '" + e.Method + "' cannot access " + e.Kind + " '" + e.Resource +
+		"', it must be declared in meta." + e.Kind
+}
+
+type capabilitiesKey struct{}
+
+func (key *capabilitiesKey) String() string {
+	return "capabilities"
+}
+
+//nolint:gochecknoglobals
+var ctxKeyCapabilities = &capabilitiesKey{}
+
+type sandbox struct {
+	method string
+	caps   *Capabilities
+}
+
+func withSandbox(ctx context.Context, method string, caps *Capabilities) context.Context {
+	if caps == nil {
+		// the method isn't restricted, even if it is called by a restricted one.
+		return context.WithValue(ctx, ctxKeyCapabilities, (*sandbox)(nil))
+	}
+	return context.WithValue(ctx, ctxKeyCapabilities, &sandbox{method: method, caps: caps})
+}
+
+func getSandbox(ctx context.Context) *sandbox {
+	if ctx == nil {
+		return nil
+	}
+	v := ctx.Value(ctxKeyCapabilities)
+	if v == nil {
+		return nil
+	}
+	return v.(*sandbox)
+}
+
+// checkPath returns a *PermissionError if the running method cannot access the file.
+func checkPath(ctx context.Context, name string) error {
+	sb := getSandbox(ctx)
+	if sb == nil || sb.caps.AllowPath(name) {
+		return nil
+	}
+	return &PermissionError{Method: sb.method, Kind: "fs", Resource: name}
+}
+
+// sandboxTransport rejects the requests to the hosts which aren't declared.
+type sandboxTransport struct {
+	base    http.RoundTripper
+	sandbox *sandbox
+}
+
+func (t *sandboxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.sandbox.caps.AllowHost(req.URL.Host) {
+		return nil, &PermissionError{Method: t.sandbox.method, Kind: "http", Resource: req.URL.Host}
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// An envGuard is the replacement of __ENV, its variables are checked against the sandbox
+// of the running method when they are read, so the object is still guarded if the code at
+// the top level of a script keeps it, e.g. "var env = __ENV;". The variables read by the
+// code at the top level are recorded while loading, they are checked against the
+// capabilities of the method once its meta description is known.
+type envGuard struct {
+	loading bool
+	reads   []string
+}
+
+// guardEnv replaces __ENV of the runtime with the guarded one.
+func guardEnv(rt *gojs.Runtime) *envGuard {
+	g := &envGuard{}
+	obj := rt.NewObject()
+	if env := rt.Get("__ENV"); env != nil && !goja.IsUndefined(env) && !goja.IsNull(env) {
+		all := env.ToObject(rt.Runtime)
+		for _, name := range all.Keys() {
+			name, value := name, all.Get(name)
+			_ = obj.DefineAccessorProperty(name, rt.ToValue(func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				if g.loading {
+					g.reads = append(g.reads, name)
+					return value
+				}
+				if sb := getSandbox(ctx); sb != nil && !sb.caps.AllowEnv(name) {
+					panic(rt.NewGoError(&PermissionError{Method: sb.method, Kind: "env", Resource: name}))
+				}
+				return value
+			}), nil, goja.FLAG_FALSE, goja.FLAG_TRUE)
+		}
+	}
+	rt.Set("__ENV", obj)
+	return g
+}
+
+// load starts to record the variables read by the code at the top level of a script.
+func (g *envGuard) load() {
+	g.loading = true
+	g.reads = nil
+}
+
+// loaded stops the recording, it returns a *PermissionError if the method cannot read one
+// of the variables.
+func (g *envGuard) loaded(method string, caps *Capabilities) error {
+	g.loading = false
+	reads := g.reads
+	g.reads = nil
+	for _, name := range reads {
+		if !caps.AllowEnv(name) {
+			return &PermissionError{Method: method, Kind: "env", Resource: name}
+		}
+	}
+	return nil
+}
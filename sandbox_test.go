@@ -0,0 +1,168 @@
+package k8
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilities(t *testing.T) {
+	caps := capabilitiesOf(map[string]interface{}{
+		"env":  []interface{}{"FOO", "REPORT_*"},
+		"http": []interface{}{"*.internal", "api.example.com", "localhost:8080"},
+		"fs":   []interface{}{"/reports"},
+	}, false)
+	require.NotNil(t, caps)
+
+	assert.True(t, caps.AllowEnv("FOO"))
+	assert.True(t, caps.AllowEnv("REPORT_TOKEN"))
+	assert.False(t, caps.AllowEnv("BAR"))
+
+	assert.True(t, caps.AllowHost("a.internal"))
+	assert.True(t, caps.AllowHost("a.b.internal:8443"))
+	assert.True(t, caps.AllowHost("API.example.com"))
+	assert.True(t, caps.AllowHost("localhost:8080"))
+	assert.False(t, caps.AllowHost("localhost:8081"))
+	assert.False(t, caps.AllowHost("internal"))
+	assert.False(t, caps.AllowHost("evil.com"))
+
+	assert.True(t, caps.AllowPath("/reports/2020/a.csv"))
+	assert.True(t, caps.AllowPath("/reports"))
+	assert.False(t, caps.AllowPath("/reports/../etc/passwd"))
+	assert.False(t, caps.AllowPath("/reports2/a.csv"))
+
+	assert.Nil(t, capabilitiesOf(map[string]interface{}{"id": "a"}, false))
+	strict := capabilitiesOf(map[string]interface{}{"id": "a", "env": []interface{}{"FOO"}}, true)
+	require.NotNil(t, strict)
+	assert.True(t, strict.AllowEnv("FOO"))
+	assert.False(t, strict.AllowHost("a.internal"))
+	assert.False(t, strict.AllowPath("/reports"))
+}
+
+func TestSandboxEnv(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+		Env:               map[string]string{"FOO": "foo", "BAR": "bar"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a", env: ["FOO"]};
+exports.default = function(name) { return __ENV[name]; };`))
+	require.NoError(t, b.Compile("/b.js", `exports.meta = {id: "b"};
+exports.default = function(name) { return __ENV[name]; };`))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	v, err := r.RunMethod(ctx, "a", "FOO")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", v)
+
+	_, err = r.RunMethod(ctx, "a", "BAR")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission denied: method 'a' cannot access env 'BAR'")
+	}
+
+	// the variables aren't restricted for the other methods.
+	v, err = r.RunMethod(ctx, "b", "BAR")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", v)
+}
+
+func TestSandboxEnvTopLevel(t *testing.T) {
+	newBuilder := func(code string) *Builder {
+		b, err := NewBuilder(&gojs.RuntimeOptions{
+			CompatibilityMode: gojs.CompatibilityModeBase.String(),
+			Env:               map[string]string{"FOO": "foo", "BAR": "bar"},
+		})
+		require.NoError(t, err)
+		require.NoError(t, b.Compile("/a.js", code))
+		require.NoError(t, b.Compile("/b.js", `exports.meta = {id: "b"};
+exports.default = function() {};`))
+		return b
+	}
+
+	// the object kept at the top level is still guarded.
+	b := newBuilder(`var env = __ENV;
+exports.meta = {id: "a", env: ["FOO"]};
+exports.default = function(name) { return env[name]; };`)
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+	v, err := r.RunMethod(context.Background(), "a", "FOO")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", v)
+	_, err = r.RunMethod(context.Background(), "a", "BAR")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission denied: method 'a' cannot access env 'BAR'")
+	}
+
+	// the variables read at the top level must be declared.
+	b = newBuilder(`var bar = __ENV.BAR;
+exports.meta = {id: "a", env: ["FOO"]};
+exports.default = function() { return bar; };`)
+	_, err = b.Build(context.Background(), nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission denied: method 'a' cannot access env 'BAR'")
+	}
+}
+
+func TestSandboxCall(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+		Env:               map[string]string{"FOO": "foo", "BAR": "bar"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a", env: ["FOO"]};
+exports.default = function(name) { return k8.call("b", name); };`))
+	require.NoError(t, b.Compile("/b.js", `exports.meta = {id: "b", env: ["BAR"]};
+exports.default = function(name) { return __ENV[name]; };`))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	// the called method runs with its own capabilities.
+	v, err := r.RunMethod(context.Background(), "a", "BAR")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", v)
+	_, err = r.RunMethod(context.Background(), "a", "FOO")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "permission denied: method 'b' cannot access env 'FOO'")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestSandboxTransport(t *testing.T) {
+	transport := &sandboxTransport{
+		base: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		}),
+		sandbox: &sandbox{method: "a", caps: &Capabilities{HTTP: []string{"*.internal"}}},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://svc.internal/a", nil)
+	require.NoError(t, err)
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, "http://example.com/a", nil)
+	require.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	permErr, ok := err.(*PermissionError)
+	if assert.True(t, ok) {
+		assert.Equal(t, "http", permErr.Kind)
+		assert.Equal(t, "example.com", permErr.Resource)
+	}
+
+	ctx := withSandbox(context.Background(), "a", &Capabilities{FS: []string{"/reports"}})
+	assert.NoError(t, checkPath(ctx, "/reports/a.csv"))
+	assert.Error(t, checkPath(ctx, "/etc/passwd"))
+	assert.NoError(t, checkPath(context.Background(), "/etc/passwd"))
+}
@@ -0,0 +1,628 @@
+package k8
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/gojs/lib"
+)
+
+// TestScriptSuffix is the suffix of the scripts which only have the test cases of the
+// methods, they are compiled with the methods but not built into the runners.
+const TestScriptSuffix = ".test.js"
+
+// IsTestScript returns true if the file is a test script.
+func IsTestScript(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), TestScriptSuffix)
+}
+
+// A scriptTest is a test case exported by a script, either a table case such as
+//
+//	exports.tests = [{name: "hello", args: {name: "k8"}, expect: "hello k8"}];
+//
+// or a function registered by describe/it in a test script, e.g.
+//
+//	describe("hello", function() {
+//	  it("greets", function() {
+//	    mock.call("users.get", {name: "k8"});
+//	    assert.equal(k8.call("hello", {id: 1}), "hello k8");
+//	  });
+//	});
+type scriptTest struct {
+	name string
+
+	// the table case
+	method    string
+	args      goja.Value
+	expect    interface{}
+	hasExpect bool
+	err       string
+	mocks     goja.Value
+
+	// the function case
+	fn goja.Callable
+}
+
+// testMocks replaces k8.call and the responses of the http requests while a test case is
+// running, the keys of the http mocks are "METHOD URL" or "URL".
+type testMocks struct {
+	calls map[string]goja.Value
+	http  map[string]goja.Value
+}
+
+func newTestMocks() *testMocks {
+	return &testMocks{
+		calls: map[string]goja.Value{},
+		http:  map[string]goja.Value{},
+	}
+}
+
+// call returns the mocked result of k8.call, the mock is called with the argument if it
+// is a function.
+func (mocks *testMocks) call(rt *gojs.Runtime, name string, arg goja.Value) (goja.Value, bool) {
+	mock, ok := mocks.calls[name]
+	if !ok {
+		return nil, false
+	}
+	if fn, ok := goja.AssertFunction(mock); ok {
+		v, err := fn(goja.Undefined(), arg)
+		return throwIfError(rt, v, err), true
+	}
+	return mock, true
+}
+
+// add adds the mocks in the object such as {call: {name: result}, http: {url: response}}.
+func (mocks *testMocks) add(rt *gojs.Runtime, v goja.Value) {
+	if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+		return
+	}
+	obj := v.ToObject(rt.Runtime)
+	for kind, target := range map[string]map[string]goja.Value{"call": mocks.calls, "http": mocks.http} {
+		items := obj.Get(kind)
+		if items == nil || goja.IsUndefined(items) || goja.IsNull(items) {
+			continue
+		}
+		itemsObj := items.ToObject(rt.Runtime)
+		for _, key := range itemsObj.Keys() {
+			target[key] = itemsObj.Get(key)
+		}
+	}
+}
+
+type testMocksKey struct{}
+
+func (key *testMocksKey) String() string {
+	return "test-mocks"
+}
+
+//nolint:gochecknoglobals
+var ctxKeyTestMocks = &testMocksKey{}
+
+func withTestMocks(ctx context.Context, mocks *testMocks) context.Context {
+	return context.WithValue(ctx, ctxKeyTestMocks, mocks)
+}
+
+func getTestMocks(ctx context.Context) *testMocks {
+	if ctx == nil {
+		return nil
+	}
+	v := ctx.Value(ctxKeyTestMocks)
+	if v == nil {
+		return nil
+	}
+	return v.(*testMocks)
+}
+
+// mockTransport answers the http requests with the mocks, a request which isn't mocked
+// fails, so the tests never reach the network.
+type mockTransport struct {
+	rt    *gojs.Runtime
+	mocks *testMocks
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	mock, ok := t.mocks.http[req.Method+" "+req.URL.String()]
+	if !ok {
+		mock, ok = t.mocks.http[req.URL.String()]
+	}
+	if !ok {
+		return nil, errors.New("unexpected request '" + req.Method + " " + req.URL.String() + "', it isn't mocked")
+	}
+
+	if fn, ok := goja.AssertFunction(mock); ok {
+		var body []byte
+		if req.Body != nil {
+			data, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return nil, err
+			}
+			body = data
+		}
+		headers := map[string]string{}
+		for k := range req.Header {
+			headers[k] = req.Header.Get(k)
+		}
+		v, err := fn(goja.Undefined(), t.rt.ToValue(map[string]interface{}{
+			"method":  req.Method,
+			"url":     req.URL.String(),
+			"headers": headers,
+			"body":    string(body),
+		}))
+		if err != nil {
+			return nil, err
+		}
+		mock = v
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Request:    req,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}
+	var body []byte
+	if mock != nil && !goja.IsUndefined(mock) && !goja.IsNull(mock) {
+		obj := mock.ToObject(t.rt.Runtime)
+		if status := obj.Get("status"); status != nil && !goja.IsUndefined(status) {
+			resp.StatusCode = int(status.ToInteger())
+		}
+		if headers := obj.Get("headers"); headers != nil && !goja.IsUndefined(headers) && !goja.IsNull(headers) {
+			headersObj := headers.ToObject(t.rt.Runtime)
+			for _, key := range headersObj.Keys() {
+				resp.Header.Set(key, headersObj.Get(key).String())
+			}
+		}
+		if v := obj.Get("body"); v != nil && !goja.IsUndefined(v) && !goja.IsNull(v) {
+			if s, ok := v.Export().(string); ok {
+				body = []byte(s)
+			} else {
+				data, err := json.Marshal(v.Export())
+				if err != nil {
+					return nil, err
+				}
+				body = data
+				if resp.Header.Get("Content-Type") == "" {
+					resp.Header.Set("Content-Type", "application/json")
+				}
+			}
+		}
+	}
+	resp.Status = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+	resp.ContentLength = int64(len(body))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// assertionError is thrown by the functions of assert.
+type assertionError struct {
+	msg string
+}
+
+func (e *assertionError) Error() string {
+	return e.msg
+}
+
+// installTestGlobals installs describe, it, assert and mock, the cases registered by it
+// are appended to cases.
+func installTestGlobals(rt *gojs.Runtime, mocks *testMocks, cases *[]*scriptTest) {
+	var prefixes []string
+	rt.Set("describe", func(call goja.FunctionCall) goja.Value {
+		fn, ok := goja.AssertFunction(call.Argument(1))
+		if !ok {
+			panic(rt.NewTypeError("describe: the second argument must be a function"))
+		}
+		prefixes = append(prefixes, call.Argument(0).String())
+		defer func() { prefixes = prefixes[:len(prefixes)-1] }()
+		v, err := fn(goja.Undefined())
+		return throwIfError(rt, v, err)
+	})
+	rt.Set("it", func(call goja.FunctionCall) goja.Value {
+		fn, ok := goja.AssertFunction(call.Argument(1))
+		if !ok {
+			panic(rt.NewTypeError("it: the second argument must be a function"))
+		}
+		*cases = append(*cases, &scriptTest{
+			name: strings.Join(append(append([]string{}, prefixes...), call.Argument(0).String()), " > "),
+			fn:   fn,
+		})
+		return goja.Undefined()
+	})
+
+	fail := func(call goja.FunctionCall, idx int, msg string) {
+		if m := call.Argument(idx); !goja.IsUndefined(m) {
+			msg = m.String() + ": " + msg
+		}
+		panic(rt.NewGoError(&assertionError{msg: msg}))
+	}
+	rt.Set("assert", map[string]interface{}{
+		"ok": func(call goja.FunctionCall) goja.Value {
+			if !call.Argument(0).ToBoolean() {
+				fail(call, 1, "expected a truthy value, but got "+call.Argument(0).String())
+			}
+			return goja.Undefined()
+		},
+		"equal": func(call goja.FunctionCall) goja.Value {
+			if !call.Argument(0).StrictEquals(call.Argument(1)) {
+				fail(call, 2, "expected "+call.Argument(1).String()+", but got "+call.Argument(0).String())
+			}
+			return goja.Undefined()
+		},
+		"deepEqual": func(call goja.FunctionCall) goja.Value {
+			if msg := compareResult(call.Argument(0).Export(), call.Argument(1).Export()); msg != "" {
+				fail(call, 2, msg)
+			}
+			return goja.Undefined()
+		},
+		"throws": func(call goja.FunctionCall) goja.Value {
+			fn, ok := goja.AssertFunction(call.Argument(0))
+			if !ok {
+				panic(rt.NewTypeError("assert.throws: the argument must be a function"))
+			}
+			_, err := fn(goja.Undefined())
+			if err == nil {
+				fail(call, 2, "expected an error")
+			}
+			if expected := call.Argument(1); !goja.IsUndefined(expected) && !strings.Contains(err.Error(), expected.String()) {
+				fail(call, 2, "expected an error containing '"+expected.String()+"', but got '"+err.Error()+"'")
+			}
+			return goja.Undefined()
+		},
+	})
+	rt.Set("mock", map[string]interface{}{
+		"call": func(call goja.FunctionCall) goja.Value {
+			mocks.calls[call.Argument(0).String()] = call.Argument(1)
+			return goja.Undefined()
+		},
+		"http": func(call goja.FunctionCall) goja.Value {
+			mocks.http[call.Argument(0).String()] = call.Argument(1)
+			return goja.Undefined()
+		},
+	})
+}
+
+// compareResult compares the values after encoding them in JSON, so that the numbers are
+// compared regardless of the types, it returns the difference or empty if they are equal.
+func compareResult(actual, expected interface{}) string {
+	a, aerr := normalizeResult(actual)
+	e, eerr := normalizeResult(expected)
+	if aerr == nil && eerr == nil && reflect.DeepEqual(a, e) {
+		return ""
+	}
+	return "expected " + formatResult(expected) + ", but got " + formatResult(actual)
+}
+
+func normalizeResult(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+func formatResult(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// loadTests runs the script in the runner and returns its test cases.
+func loadTests(ctx context.Context, r *Runner, pgm Program, mocks *testMocks) ([]*scriptTest, error) {
+	rt := r.Runtime
+	var cases []*scriptTest
+	installTestGlobals(rt, mocks, &cases)
+	exports := gojs.InstantiateEnv(rt)
+	if _, err := rt.RunProgram(ctx, pgm.Program); err != nil {
+		return nil, r.sourceMaps.rewrite(err)
+	}
+
+	method := ""
+	if !IsTestScript(pgm.Filename) {
+		method = "default"
+		if metaV := exports.Get("meta"); metaV != nil && !goja.IsUndefined(metaV) && !goja.IsNull(metaV) {
+			meta, _ := metaV.Export().(map[string]interface{})
+			if id, ok := meta["id"]; ok && id != nil {
+				method = methodKey(fmt.Sprint(id), meta)
+			}
+		}
+	}
+
+	var table []*scriptTest
+	if testsV := exports.Get("tests"); testsV != nil && !goja.IsUndefined(testsV) && !goja.IsNull(testsV) {
+		tests := testsV.ToObject(rt.Runtime)
+		length := int(tests.Get("length").ToInteger())
+		for i := 0; i < length; i++ {
+			item := tests.Get(strconv.Itoa(i)).ToObject(rt.Runtime)
+			c := &scriptTest{name: "#" + strconv.Itoa(i+1), method: method, args: item.Get("args")}
+			if name := item.Get("name"); name != nil && !goja.IsUndefined(name) {
+				c.name = name.String()
+			}
+			if m := item.Get("method"); m != nil && !goja.IsUndefined(m) {
+				c.method = m.String()
+			}
+			if expect := item.Get("expect"); expect != nil {
+				c.expect, c.hasExpect = expect.Export(), true
+			}
+			if e := item.Get("error"); e != nil && !goja.IsUndefined(e) {
+				c.err = e.String()
+			}
+			if c.method == "" {
+				return nil, errors.New("method of the test '" + c.name + "' is missing")
+			}
+			c.mocks = item.Get("mocks")
+			table = append(table, c)
+		}
+	}
+	return append(table, cases...), nil
+}
+
+// run runs the test case and returns the failure, it is empty if the case passes.
+func (c *scriptTest) run(ctx context.Context, r *Runner) string {
+	if c.fn != nil {
+		r.Runtime.SetContext(ctx)
+		if _, err := c.fn(goja.Undefined()); err != nil {
+			return r.sourceMaps.rewrite(err).Error()
+		}
+		return ""
+	}
+
+	args := c.args
+	if args == nil {
+		args = goja.Undefined()
+	}
+	v, err := r.RunMethod(ctx, c.method, args)
+	if c.err != "" {
+		if err == nil {
+			return "expected an error containing '" + c.err + "', but got " + formatResult(v)
+		}
+		if !strings.Contains(err.Error(), c.err) {
+			return "expected an error containing '" + c.err + "', but got '" + err.Error() + "'"
+		}
+		return ""
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if c.hasExpect {
+		return compareResult(v, c.expect)
+	}
+	return ""
+}
+
+// A TestResult is the result of a test case of the scripts.
+type TestResult struct {
+	// Suite is the name of the script.
+	Suite    string
+	Name     string
+	Duration time.Duration
+	// Failure is empty if the case passes.
+	Failure string
+}
+
+// Passed returns true if the case passes.
+func (result *TestResult) Passed() bool {
+	return result.Failure == ""
+}
+
+// A TestReport is the results of the test cases of the scripts.
+type TestReport struct {
+	Results []TestResult
+}
+
+// Failures returns the number of the failed cases.
+func (report *TestReport) Failures() int {
+	count := 0
+	for i := range report.Results {
+		if !report.Results[i].Passed() {
+			count++
+		}
+	}
+	return count
+}
+
+// Test runs the test cases exported by the scripts, exports.tests in a method script or
+// the cases in a test script (*.test.js). Each case runs on a new runner with its own
+// mocks, and the http requests which are not mocked fail.
+func (b *Builder) Test(ctx context.Context) (*TestReport, error) {
+	// the invocations of the tests are never recorded in the metrics of the service.
+	tb := *b
+	tb.metrics = NewMetrics()
+
+	report := &TestReport{}
+	for _, pgm := range b.programs {
+		r, err := tb.Build(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		cases, err := loadTests(ctx, r, pgm, newTestMocks())
+		if err != nil {
+			report.Results = append(report.Results, TestResult{
+				Suite:   pgm.Filename,
+				Name:    "load",
+				Failure: err.Error(),
+			})
+			continue
+		}
+
+		for i, test := range cases {
+			// every case runs on a new runner, so the cases never share state, even
+			// with the loading above.
+			if r, err = tb.Build(ctx, nil); err != nil {
+				return nil, err
+			}
+			mocks := newTestMocks()
+			caseCtx := withTestMocks(ctx, mocks)
+			caseCtx = lib.WithState(caseCtx, &lib.State{Transport: &mockTransport{rt: r.Runtime, mocks: mocks}})
+			isolated, err := loadTests(caseCtx, r, pgm, mocks)
+			if err != nil {
+				return nil, err
+			}
+			// the cases are matched by name, the script may define other cases when it
+			// is loaded again.
+			reloaded := findTest(isolated, test.name, countTests(cases[:i], test.name))
+			if reloaded == nil {
+				report.Results = append(report.Results, TestResult{
+					Suite:   pgm.Filename,
+					Name:    test.name,
+					Failure: "the case isn't defined when the script is loaded again, the cases must not depend on the state",
+				})
+				continue
+			}
+
+			mocks.add(r.Runtime, reloaded.mocks)
+
+			started := time.Now()
+			failure := reloaded.run(caseCtx, r)
+			report.Results = append(report.Results, TestResult{
+				Suite:    pgm.Filename,
+				Name:     reloaded.name,
+				Duration: time.Since(started),
+				Failure:  failure,
+			})
+		}
+	}
+	return report, nil
+}
+
+// countTests returns the number of the cases with the name.
+func countTests(cases []*scriptTest, name string) int {
+	n := 0
+	for _, test := range cases {
+		if test.name == name {
+			n++
+		}
+	}
+	return n
+}
+
+// findTest returns the nth case with the name, it is nil if the case doesn't exist.
+func findTest(cases []*scriptTest, name string, nth int) *scriptTest {
+	for _, test := range cases {
+		if test.name != name {
+			continue
+		}
+		if nth == 0 {
+			return test
+		}
+		nth--
+	}
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// WriteJUnit writes the report in the JUnit XML format, each script is a test suite.
+func (report *TestReport) WriteJUnit(w io.Writer) error {
+	suites := junitTestSuites{Tests: len(report.Results), Failures: report.Failures()}
+	index := map[string]int{}
+	durations := map[string]time.Duration{}
+	for _, result := range report.Results {
+		idx, ok := index[result.Suite]
+		if !ok {
+			idx = len(suites.Suites)
+			index[result.Suite] = idx
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: result.Suite})
+		}
+		suite := &suites.Suites[idx]
+		tc := junitTestCase{
+			Name:      result.Name,
+			ClassName: result.Suite,
+			Time:      formatSeconds(result.Duration),
+		}
+		if !result.Passed() {
+			message := result.Failure
+			if idx := strings.IndexByte(message, '\n'); idx >= 0 {
+				message = message[:idx]
+			}
+			tc.Failure = &junitFailure{Message: message, Text: result.Failure}
+			suite.Failures++
+		}
+		suite.Tests++
+		durations[result.Suite] += result.Duration
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for i := range suites.Suites {
+		suites.Suites[i].Time = formatSeconds(durations[suites.Suites[i].Name])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteTAP writes the report in the TAP version 13 format.
+func (report *TestReport) WriteTAP(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("TAP version 13\n")
+	buf.WriteString("1.." + strconv.Itoa(len(report.Results)) + "\n")
+	for i, result := range report.Results {
+		status := "ok"
+		if !result.Passed() {
+			status = "not ok"
+		}
+		buf.WriteString(status + " " + strconv.Itoa(i+1) + " - " + result.Suite + ": " + result.Name + "\n")
+		if !result.Passed() {
+			buf.WriteString("  ---\n  message: |\n")
+			for _, line := range strings.Split(result.Failure, "\n") {
+				buf.WriteString("    " + line + "\n")
+			}
+			buf.WriteString("  ...\n")
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,143 @@
+package k8
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptTests(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.SetMetrics(NewMetrics())
+	b.RegisterModule("fetch", ModuleFunc(fetchModule))
+
+	require.NoError(t, b.Compile("/hello.js", `exports.meta = {id: "hello"};
+exports.default = function(args) {
+	var user = k8.call("users.get", args.id);
+	return "hello " + user.name;
+};
+exports.tests = [
+	{name: "greets", args: {id: 1}, expect: "hello k8", mocks: {call: {"users.get": {name: "k8"}}}},
+	{name: "wrong", args: {id: 1}, expect: "hello", mocks: {call: {"users.get": {name: "k8"}}}},
+	{name: "bad args", args: null, error: "TypeError"}
+];`))
+	require.NoError(t, b.Compile("/users.js", `exports.meta = {id: "users.get"};
+exports.default = function(id) { return {name: "user" + id}; };`))
+	require.NoError(t, b.Compile("/hello.test.js", `var count = 0;
+describe("hello", function() {
+	it("calls users.get", function() {
+		count++;
+		assert.equal(count, 1, "isolated");
+		assert.equal(k8.call("hello", {id: 2}), "hello user2");
+	});
+	it("mocks http", function() {
+		count++;
+		assert.equal(count, 1, "isolated");
+		mock.http("GET http://users.internal/1", {body: {name: "remote"}});
+		assert.deepEqual(JSON.parse(k8.fetch("http://users.internal/1")), {name: "remote"});
+		assert.throws(function() { k8.fetch("http://users.internal/2"); }, "it isn't mocked");
+	});
+});`))
+
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Len(t, r.Methods, 2, "the test scripts aren't methods")
+
+	report, err := b.Test(context.Background())
+	require.NoError(t, err)
+
+	var names []string
+	for _, result := range report.Results {
+		names = append(names, result.Suite+": "+result.Name)
+		if result.Name == "wrong" {
+			assert.Equal(t, `expected "hello", but got "hello k8"`, result.Failure)
+		} else {
+			assert.True(t, result.Passed(), result.Name+": "+result.Failure)
+		}
+	}
+	assert.Equal(t, []string{
+		"/hello.js: greets",
+		"/hello.js: wrong",
+		"/hello.js: bad args",
+		"/hello.test.js: hello > calls users.get",
+		"/hello.test.js: hello > mocks http",
+	}, names)
+	assert.Equal(t, 1, report.Failures())
+
+	// the invocations of the tests aren't recorded in the metrics of the builder.
+	var metrics strings.Builder
+	_, err = b.Metrics().WriteTo(&metrics)
+	require.NoError(t, err)
+	assert.NotContains(t, metrics.String(), "k8_invocations_total{")
+
+	var tap bytes.Buffer
+	require.NoError(t, report.WriteTAP(&tap))
+	assert.Contains(t, tap.String(), "TAP version 13\n1..5\nok 1 - /hello.js: greets\nnot ok 2 - /hello.js: wrong\n")
+	assert.Contains(t, tap.String(), "  ---\n  message: |\n    expected \"hello\", but got \"hello k8\"\n  ...\n")
+
+	var junit bytes.Buffer
+	require.NoError(t, report.WriteJUnit(&junit))
+	assert.Contains(t, junit.String(), `<testsuites tests="5" failures="1">`)
+	assert.Contains(t, junit.String(), `<testsuite name="/hello.js" tests="3" failures="1"`)
+	assert.Contains(t, junit.String(), `<failure message="expected &#34;hello&#34;, but got &#34;hello k8&#34;">`)
+}
+
+func TestScriptTestsChangedOnReload(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.SetMetrics(NewMetrics())
+	loads := 0
+	b.RegisterModule("loads", ModuleFunc(func(rt *gojs.Runtime) interface{} {
+		return func(call goja.FunctionCall) goja.Value {
+			loads++
+			return rt.ToValue(loads)
+		}
+	}))
+	// the script is run when the runner is built and when the cases are loaded, "a"
+	// disappears after the first runner.
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() { return 1; };
+exports.tests = k8.loads() <= 2 ?
+	[{name: "a", expect: 1}, {name: "b", expect: 1}] :
+	[{name: "b", expect: 1}];`))
+
+	report, err := b.Test(context.Background())
+	require.NoError(t, err)
+	require.Len(t, report.Results, 2)
+	assert.Equal(t, "a", report.Results[0].Name)
+	assert.Contains(t, report.Results[0].Failure, "isn't defined when the script is loaded again")
+	assert.Equal(t, "b", report.Results[1].Name)
+	assert.True(t, report.Results[1].Passed(), report.Results[1].Failure)
+}
+
+func TestScriptTestsNewRunner(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.SetMetrics(NewMetrics())
+	// the script is loaded only once on the runner of every case, the first one too.
+	require.NoError(t, b.Compile("/a.test.js", `this.loads = (this.loads || 0) + 1;
+describe("a", function() {
+	it("first", function() { assert.equal(loads, 1, "loads"); });
+	it("second", function() { assert.equal(loads, 1, "loads"); });
+});`))
+
+	report, err := b.Test(context.Background())
+	require.NoError(t, err)
+	require.Len(t, report.Results, 2)
+	for _, result := range report.Results {
+		assert.True(t, result.Passed(), result.Name+": "+result.Failure)
+	}
+}
@@ -0,0 +1,317 @@
+package k8
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/runner-mei/log"
+	"github.com/runner-mei/loong"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ServerOptions configures the http handlers registered by RegisterHandlers.
+type ServerOptions struct {
+	// AdminToken protects the admin endpoints, they are denied if it is empty.
+	AdminToken string
+	// DebugPublic lets everyone see the console output of the debug requests.
+	DebugPublic bool
+	// ConsoleLimit is the max number of the console entries of a debug request.
+	ConsoleLimit int
+	// PoolSize is the number of the runners, it is 100 by default.
+	PoolSize int
+	Auditor  *Auditor
+	// State is the http client of the scripts.
+	State           *lib.State
+	ReplIdleTimeout time.Duration
+	ReplEvalTimeout time.Duration
+	// Reload rebuilds the scripts for POST /k8/_/reload, it is unsupported if nil.
+	Reload func() (*Builder, error)
+	// SignatureRequired disables the endpoints running the code which isn't signed,
+	// /k8/_/run_script and /k8/_/repl.
+	SignatureRequired bool
+}
+
+// RegisterHandlers registers the http handlers of the methods built by the builder, the
+// runners are created at once and pooled.
+func RegisterHandlers(engine *loong.Engine, b *Builder, opts ServerOptions) error {
+	ctx := context.Background()
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 100
+	}
+	if opts.ReplIdleTimeout <= 0 {
+		opts.ReplIdleTimeout = 10 * time.Minute
+	}
+	if opts.ReplEvalTimeout <= 0 {
+		opts.ReplEvalTimeout = 30 * time.Second
+	}
+	state := opts.State
+	if state == nil {
+		s, err := lib.NewState(log.Empty(), lib.Options{})
+		if err != nil {
+			return err
+		}
+		state = s
+	}
+
+	load := func() (*deployment, error) {
+		if opts.Reload == nil {
+			return nil, errors.New("reload is not supported")
+		}
+		b, err := opts.Reload()
+		if err != nil {
+			return nil, err
+		}
+		d, err := newDeployment(ctx, b, opts.PoolSize)
+		if err != nil {
+			return nil, err
+		}
+		warnMeta(state, d)
+		return d, nil
+	}
+	d, err := newDeployment(ctx, b, opts.PoolSize)
+	if err != nil {
+		return err
+	}
+	warnMeta(state, d)
+	current := &deployments{}
+	current.set(d)
+
+	adminToken := opts.AdminToken
+	auditor := opts.Auditor
+	debug := &debugMode{
+		adminToken:   adminToken,
+		public:       opts.DebugPublic,
+		consoleLimit: opts.ConsoleLimit,
+	}
+
+	// The version of the method is selected by "/k8/name@v2" or the header
+	// "X-K8-Method-Version: v2", it is the latest stable version by default.
+	engine.Any("/k8/:name", func(c *loong.Context) error {
+		name := c.Param("name")
+		if version := c.Request().Header.Get("X-K8-Method-Version"); version != "" && !strings.Contains(name, "@") {
+			name += "@" + version
+		}
+
+		d := current.get()
+		span, ctx := startServerSpan(c, d.builder.Tracer(), "k8.method")
+		defer span.End()
+		span.SetAttributes(attribute.String("k8.method", name))
+
+		r, err := d.pool.Get(ctx)
+		if err != nil {
+			return c.ReturnError(err)
+		}
+		defer d.pool.Put(r)
+
+		args := r.Runtime.NewObject()
+		for k, v := range c.QueryParams() {
+			if len(v) == 1 {
+				args.Set(k, v[0])
+			} else {
+				args.Set(k, v)
+			}
+		}
+		ctx = WithRequestInfo(lib.WithState(ctx, state), requestInfoOf(c, auditor))
+		capture := debug.capture(c)
+		if capture != nil {
+			ctx = WithConsoleCapture(ctx, capture)
+		}
+		recorded := auditor.Snapshot(args)
+		started := time.Now()
+		result, err := r.RunMethod(ctx, name, args)
+		auditor.Record(c, name, r.methodMeta(name), recorded, "", started, err)
+		if err != nil {
+			return debug.returnError(c, capture, err)
+		}
+		if resp, ok := result.(*Response); ok && !isDebugRequest(c) {
+			return resp.write(c)
+		}
+		return debug.returnResult(c, capture, result)
+	})
+
+	engine.POST("/k8/_/run_script", func(c *loong.Context) error {
+		if opts.SignatureRequired {
+			return c.ReturnError(ErrUnsignedCode, http.StatusForbidden)
+		}
+		d := current.get()
+		span, ctx := startServerSpan(c, d.builder.Tracer(), "k8.run_script")
+		defer span.End()
+
+		data, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.ReturnError(err)
+		}
+
+		// the script runs in its own runtime, so it never changes the globals, such as
+		// k8.call, of the pooled runners.
+		tmpR, err := d.builder.BuildString(ctx, nil, string(data))
+		if err != nil {
+			auditor.Record(c, "_/run_script", nil, nil, string(data), time.Now(), err)
+			return c.ReturnError(err)
+		}
+
+		args := tmpR.Runtime.NewObject()
+		for k, v := range c.QueryParams() {
+			if len(v) == 1 {
+				args.Set(k, v[0])
+			} else {
+				args.Set(k, v)
+			}
+		}
+
+		ctx = WithRequestInfo(lib.WithState(ctx, state), requestInfoOf(c, auditor))
+		capture := debug.capture(c)
+		if capture != nil {
+			ctx = WithConsoleCapture(ctx, capture)
+		}
+		recorded := auditor.Snapshot(args)
+		started := time.Now()
+		result, err := tmpR.RunDefaultMethod(ctx, args)
+		auditor.Record(c, "_/run_script", nil, recorded, string(data), started, err)
+		if err != nil {
+			return debug.returnError(c, capture, err)
+		}
+		return debug.returnResult(c, capture, result)
+	})
+
+	engine.POST("/k8/_/rpc", func(c *loong.Context) error {
+		d := current.get()
+		span, ctx := startServerSpan(c, d.builder.Tracer(), "k8.rpc")
+		defer span.End()
+
+		r, err := d.pool.Get(ctx)
+		if err != nil {
+			return c.ReturnError(err)
+		}
+		defer d.pool.Put(r)
+
+		ctx = WithRequestInfo(lib.WithState(ctx, state), requestInfoOf(c, auditor))
+		return serveRPC(c, ctx, r, auditor)
+	})
+
+	engine.GET("/k8/_/repl", func(c *loong.Context) error {
+		if !isAdmin(c, adminToken) {
+			return c.ReturnError(errors.New("permission denied"), http.StatusForbidden)
+		}
+		if opts.SignatureRequired {
+			return c.ReturnError(ErrUnsignedCode, http.StatusForbidden)
+		}
+
+		r, err := current.get().builder.Build(ctx, nil)
+		if err != nil {
+			return c.ReturnError(err)
+		}
+		return serveREPL(c, lib.WithState(c.StdContext, state), r, auditor, opts.ReplIdleTimeout, opts.ReplEvalTimeout)
+	})
+
+	engine.GET("/k8/_/metrics", func(c *loong.Context) error {
+		c.Response().Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Response().WriteHeader(http.StatusOK)
+		_, err := current.get().builder.Metrics().WriteTo(c.Response())
+		return err
+	})
+
+	// The scripts and the bundles are reloaded as a whole, the current deployment
+	// is kept if any of them is broken.
+	engine.POST("/k8/_/reload", func(c *loong.Context) error {
+		if !isAdmin(c, adminToken) {
+			return c.ReturnError(errors.New("permission denied"), http.StatusForbidden)
+		}
+
+		d, err := current.reload(load)
+		if err != nil {
+			return c.ReturnError(err)
+		}
+		return c.ReturnQueryResult(d.methods)
+	})
+
+	// The test cases of the scripts are run on the runners which are not in the
+	// pool, the report is in the TAP format, or JUnit XML if format=junit.
+	engine.POST("/k8/_/test", func(c *loong.Context) error {
+		if !isAdmin(c, adminToken) {
+			return c.ReturnError(errors.New("permission denied"), http.StatusForbidden)
+		}
+
+		report, err := current.get().builder.Test(c.StdContext)
+		if err != nil {
+			return c.ReturnError(err)
+		}
+		status := http.StatusOK
+		if report.Failures() > 0 {
+			status = http.StatusExpectationFailed
+		}
+		if c.QueryParam("format") == "junit" {
+			c.Response().Header().Set("Content-Type", "application/xml; charset=utf-8")
+			c.Response().WriteHeader(status)
+			return report.WriteJUnit(c.Response())
+		}
+		c.Response().Header().Set("Content-Type", "text/plain; charset=utf-8")
+		c.Response().WriteHeader(status)
+		return report.WriteTAP(c.Response())
+	})
+
+	engine.GET("/k8/meta/methods", func(c *loong.Context) error {
+		return c.ReturnQueryResult(current.get().methods)
+	})
+	return nil
+}
+
+// A Response is returned by k8.response(body, options), the body is written as is instead
+// of the JSON of the result, e.g.
+//
+//	return k8.response(html, {status: 200, contentType: "text/html; charset=utf-8", headers: {"Cache-Control": "no-cache"}});
+type Response struct {
+	Status      int               `json:"status,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body"`
+}
+
+func (resp *Response) write(c *loong.Context) error {
+	for k, v := range resp.Headers {
+		c.Response().Header().Set(k, v)
+	}
+	contentType := resp.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	c.Response().Header().Set("Content-Type", contentType)
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	c.Response().WriteHeader(status)
+	_, err := c.Response().Write([]byte(resp.Body))
+	return err
+}
+
+// responseModule is exposed as the function k8.response(body, options).
+func responseModule(rt *gojs.Runtime) interface{} {
+	return func(call goja.FunctionCall) goja.Value {
+		resp := &Response{Body: call.Argument(0).String()}
+		if v := call.Argument(1); !goja.IsUndefined(v) && !goja.IsNull(v) {
+			opts := v.ToObject(rt.Runtime)
+			if status := opts.Get("status"); status != nil && !goja.IsUndefined(status) {
+				resp.Status = int(status.ToInteger())
+			}
+			if contentType := opts.Get("contentType"); contentType != nil && !goja.IsUndefined(contentType) {
+				resp.ContentType = contentType.String()
+			}
+			if headers := opts.Get("headers"); headers != nil && !goja.IsUndefined(headers) && !goja.IsNull(headers) {
+				obj := headers.ToObject(rt.Runtime)
+				resp.Headers = map[string]string{}
+				for _, k := range obj.Keys() {
+					resp.Headers[k] = obj.Get(k).String()
+				}
+			}
+		}
+		return rt.ToValue(resp)
+	}
+}
@@ -0,0 +1,135 @@
+package k8
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/loong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startTestServer(t *testing.T, b *Builder, opts ServerOptions) *httptest.Server {
+	engine := loong.New()
+	require.NoError(t, RegisterHandlers(engine, b, opts))
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func doRequest(t *testing.T, method, url, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data)
+}
+
+func TestRunScriptKeepsPooledRunners(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() { return "a:" + k8.call("b"); };`))
+	require.NoError(t, b.Compile("/b.js", `exports.meta = {id: "b"};
+exports.default = function() { return "b"; };`))
+	srv := startTestServer(t, b, ServerOptions{PoolSize: 1})
+
+	status, body := doRequest(t, http.MethodPost, srv.URL+"/k8/_/run_script",
+		`exports.default = function() { return "script"; };`)
+	assert.Equal(t, http.StatusOK, status, body)
+	assert.Contains(t, body, "script")
+
+	// the only pooled runner still calls the methods of the scripts.
+	status, body = doRequest(t, http.MethodGet, srv.URL+"/k8/a", "")
+	assert.Equal(t, http.StatusOK, status, body)
+	assert.Contains(t, body, "a:b")
+}
+
+func TestRunScriptConcurrently(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode:    gojs.CompatibilityModeBase.String(),
+		IncludeSystemEnvVars: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() { return "a"; };`))
+	srv := startTestServer(t, b, ServerOptions{PoolSize: 1})
+
+	// every script is built on a new runtime, they must not share the options.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, body := doRequest(t, http.MethodPost, srv.URL+"/k8/_/run_script",
+				`exports.default = function() { return "script"; };`)
+			assert.Equal(t, http.StatusOK, status, body)
+			assert.Contains(t, body, "script")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSignatureRequiredDisablesUnsignedCode(t *testing.T) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", `exports.meta = {id: "a"};
+exports.default = function() { return "a"; };`))
+	srv := startTestServer(t, b, ServerOptions{PoolSize: 1, AdminToken: "secret", SignatureRequired: true})
+
+	status, body := doRequest(t, http.MethodPost, srv.URL+"/k8/_/run_script",
+		`exports.default = function() { return "script"; };`)
+	assert.Equal(t, http.StatusForbidden, status, body)
+	assert.Contains(t, body, ErrUnsignedCode.Error())
+
+	status, body = doRequest(t, http.MethodGet, srv.URL+"/k8/_/repl?admin_token=secret", "")
+	assert.Equal(t, http.StatusForbidden, status, body)
+	assert.Contains(t, body, ErrUnsignedCode.Error())
+
+	// the signed scripts still run.
+	status, body = doRequest(t, http.MethodGet, srv.URL+"/k8/a", "")
+	assert.Equal(t, http.StatusOK, status, body)
+}
+
+func TestErrorSourceWithoutConsole(t *testing.T) {
+	// minify the script into one line with an inline source map.
+	result := api.Transform(`
+exports.meta = {id: "a"};
+exports.default = function() {
+	throw new Error("aaaa");
+};
+`, api.TransformOptions{
+		Loader:           api.LoaderJS,
+		Sourcefile:       "/src/a.js",
+		Sourcemap:        api.SourceMapInline,
+		MinifyWhitespace: true,
+	})
+	require.Empty(t, result.Errors)
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Compile("/a.js", string(result.Code)))
+	srv := startTestServer(t, b, ServerOptions{PoolSize: 1})
+
+	// the caller isn't allowed to see the console, but the source is still attached.
+	status, body := doRequest(t, http.MethodGet, srv.URL+"/k8/a", "")
+	assert.NotEqual(t, http.StatusOK, status, body)
+	assert.Contains(t, body, `/src/a.js:4:`)
+	assert.Contains(t, body, `throw new Error(\"aaaa\");`)
+	assert.NotContains(t, body, "console")
+}
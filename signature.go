@@ -0,0 +1,89 @@
+package k8
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/godoc/vfs"
+)
+
+// SignatureExt is appended to the name of a script or bundle to get the name of its
+// detached signature, the signature is the raw 64 bytes or in base64.
+const SignatureExt = ".sig"
+
+//nolint:gochecknoglobals
+var (
+	ErrSignatureMissing = errors.New("signature is missing")
+	ErrSignatureInvalid = errors.New("signature is invalid")
+	// ErrUnsignedCode is returned by the endpoints running the code which isn't signed,
+	// such as /k8/_/run_script, if the signatures are required.
+	ErrUnsignedCode = errors.New("unsigned code is disabled since the signatures are required")
+)
+
+// A Verifier checks the detached ed25519 signatures of the scripts and bundles, a file is
+// accepted if it is signed by any of the keys.
+type Verifier struct {
+	Keys    []ed25519.PublicKey
+	Auditor *Auditor
+}
+
+// ParsePublicKeys parses the public keys separated by commas, each key is 32 bytes in
+// base64.
+func ParsePublicKeys(s string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, text := range strings.Split(s, ",") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid public key '"+text+"'")
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid public key '" + text + "': the size must be 32 bytes")
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return keys, nil
+}
+
+// Verify checks the signature of the file, it is read from filename + SignatureExt. Every
+// check is recorded by the auditor.
+func (v *Verifier) Verify(fs vfs.NameSpace, filename string, data []byte) error {
+	started := time.Now()
+	err := v.verify(fs, filename, data)
+	v.Auditor.Record(nil, "_/verify_signature", nil, map[string]interface{}{"file": filename}, "", started, err)
+	return err
+}
+
+func (v *Verifier) verify(fs vfs.NameSpace, filename string, data []byte) error {
+	sig, err := vfs.ReadFile(fs, filename+SignatureExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Wrap(ErrSignatureMissing, filename)
+		}
+		return err
+	}
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+		if err != nil {
+			return errors.Wrap(ErrSignatureInvalid, filename)
+		}
+		sig = decoded
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return errors.Wrap(ErrSignatureInvalid, filename)
+	}
+	for _, key := range v.Keys {
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	}
+	return errors.Wrap(ErrSignatureInvalid, filename)
+}
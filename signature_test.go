@@ -0,0 +1,71 @@
+package k8
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/godoc/vfs"
+	"golang.org/x/tools/godoc/vfs/mapfs"
+)
+
+func TestVerifier(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, otherPrivate, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	script := `exports.default = function() {};`
+	ns := vfs.NameSpace{}
+	ns.Bind("/", mapfs.New(map[string]string{
+		"raw.js":       script,
+		"raw.js.sig":   string(ed25519.Sign(private, []byte(script))),
+		"text.js":      script,
+		"text.js.sig":  base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(script))) + "\n",
+		"other.js":     script,
+		"other.js.sig": string(ed25519.Sign(otherPrivate, []byte(script))),
+		"unsigned.js":  script,
+	}), "/", vfs.BindReplace)
+
+	keys, err := ParsePublicKeys(base64.StdEncoding.EncodeToString(public))
+	require.NoError(t, err)
+
+	var records []*AuditRecord
+	verifier := &Verifier{
+		Keys: keys,
+		Auditor: &Auditor{Sink: AuditSinkFunc(func(record *AuditRecord) error {
+			records = append(records, record)
+			return nil
+		})},
+	}
+
+	assert.NoError(t, verifier.Verify(ns, "/raw.js", []byte(script)))
+	assert.NoError(t, verifier.Verify(ns, "/text.js", []byte(script)))
+
+	err = verifier.Verify(ns, "/raw.js", []byte(script+" "))
+	assert.Equal(t, ErrSignatureInvalid, errors.Cause(err))
+	err = verifier.Verify(ns, "/other.js", []byte(script))
+	assert.Equal(t, ErrSignatureInvalid, errors.Cause(err))
+	err = verifier.Verify(ns, "/unsigned.js", []byte(script))
+	assert.Equal(t, ErrSignatureMissing, errors.Cause(err))
+	assert.EqualError(t, err, "/unsigned.js: signature is missing")
+
+	require.Len(t, records, 5)
+	assert.Equal(t, AuditSuccess, records[0].Outcome)
+	assert.Equal(t, AuditFailure, records[4].Outcome)
+	assert.Equal(t, map[string]interface{}{"file": "/unsigned.js"}, records[4].Args)
+	assert.WithinDuration(t, time.Now(), records[4].Time, time.Minute)
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	_, err := ParsePublicKeys("YWJj")
+	assert.Error(t, err)
+
+	keys, err := ParsePublicKeys("")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,260 @@
+package k8
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// mapping maps a position of the generated code to the original source, all fields are
+// zero-based as in the source map.
+type mapping struct {
+	genColumn int
+	source    int
+	line      int
+	column    int
+}
+
+// sourceMap is a decoded source map of the version 3, see
+// https://sourcemaps.info/spec.html
+type sourceMap struct {
+	sources  []string
+	contents []string
+	lines    [][]mapping
+}
+
+func parseSourceMap(data []byte) (*sourceMap, error) {
+	var raw struct {
+		Version        int       `json:"version"`
+		SourceRoot     string    `json:"sourceRoot"`
+		Sources        []string  `json:"sources"`
+		SourcesContent []*string `json:"sourcesContent"`
+		Mappings       string    `json:"mappings"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrap(err, "invalid source map")
+	}
+	if raw.Version != 3 {
+		return nil, errors.New("unsupported source map version " + strconv.Itoa(raw.Version))
+	}
+
+	sm := &sourceMap{
+		sources:  make([]string, len(raw.Sources)),
+		contents: make([]string, len(raw.Sources)),
+	}
+	for i, source := range raw.Sources {
+		sm.sources[i] = raw.SourceRoot + source
+		if i < len(raw.SourcesContent) && raw.SourcesContent[i] != nil {
+			sm.contents[i] = *raw.SourcesContent[i]
+		}
+	}
+
+	var source, line, column int
+	for _, group := range strings.Split(raw.Mappings, ";") {
+		var mappings []mapping
+		genColumn := 0
+		for _, segment := range strings.Split(group, ",") {
+			if segment == "" {
+				continue
+			}
+			fields, err := decodeVLQ(segment)
+			if err != nil {
+				return nil, err
+			}
+			genColumn += fields[0]
+			if len(fields) < 4 {
+				continue
+			}
+			source += fields[1]
+			line += fields[2]
+			column += fields[3]
+			mappings = append(mappings, mapping{genColumn: genColumn, source: source, line: line, column: column})
+		}
+		sm.lines = append(sm.lines, mappings)
+	}
+	return sm, nil
+}
+
+// find returns the original position of the generated position, line and column are
+// one-based as in the messages of goja.
+func (sm *sourceMap) find(line, column int) (string, int, int, bool) {
+	if line < 1 || line > len(sm.lines) {
+		return "", 0, 0, false
+	}
+	mappings := sm.lines[line-1]
+	if len(mappings) == 0 {
+		return "", 0, 0, false
+	}
+	idx := sort.Search(len(mappings), func(i int) bool {
+		return mappings[i].genColumn > column-1
+	})
+	if idx > 0 {
+		idx--
+	}
+	m := mappings[idx]
+	if m.source < 0 || m.source >= len(sm.sources) {
+		return "", 0, 0, false
+	}
+	return sm.sources[m.source], m.line + 1, m.column + 1, true
+}
+
+// sourceLine returns the line of the original source, it is empty if the content of the
+// source is unknown.
+func (sm *sourceMap) sourceLine(source string, line int) string {
+	for i, name := range sm.sources {
+		if name != source || sm.contents[i] == "" {
+			continue
+		}
+		lines := strings.Split(sm.contents[i], "\n")
+		if line < 1 || line > len(lines) {
+			return ""
+		}
+		return strings.TrimRight(lines[line-1], "\r")
+	}
+	return ""
+}
+
+// loadContents reads the sources which aren't embedded in the source map.
+func (sm *sourceMap) loadContents(read func(source string) ([]byte, error)) {
+	for i, source := range sm.sources {
+		if sm.contents[i] != "" {
+			continue
+		}
+		if data, err := read(source); err == nil {
+			sm.contents[i] = string(data)
+		}
+	}
+}
+
+//nolint:gochecknoglobals
+var sourceMappingURLPattern = regexp.MustCompile(`(?m)^[ \t]*//[#@][ \t]*sourceMappingURL=(\S+)[ \t]*$`)
+
+// sourceMappingURL returns the url in the last "//# sourceMappingURL=" comment of the code.
+func sourceMappingURL(code string) string {
+	matches := sourceMappingURLPattern.FindAllStringSubmatch(code, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1][1]
+}
+
+// decodeDataURL decodes the source map embedded in the url, e.g.
+// "data:application/json;base64,eyJ2ZXJzaW9uIjozfQ==".
+func decodeDataURL(url string) ([]byte, bool, error) {
+	if !strings.HasPrefix(url, "data:") {
+		return nil, false, nil
+	}
+	idx := strings.IndexByte(url, ',')
+	if idx < 0 {
+		return nil, true, errors.New("invalid source map url: ',' is missing")
+	}
+	if !strings.HasSuffix(url[:idx], ";base64") {
+		return []byte(url[idx+1:]), true, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(url[idx+1:])
+	if err != nil {
+		return nil, true, errors.Wrap(err, "invalid source map url")
+	}
+	return data, true, nil
+}
+
+const vlqChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+func decodeVLQ(segment string) ([]int, error) {
+	var fields []int
+	value, shift := 0, uint(0)
+	for i := 0; i < len(segment); i++ {
+		digit := strings.IndexByte(vlqChars, segment[i])
+		if digit < 0 {
+			return nil, errors.New("invalid source map: unexpected character '" + segment[i:i+1] + "' in mappings")
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+		if value&1 != 0 {
+			fields = append(fields, -(value >> 1))
+		} else {
+			fields = append(fields, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, errors.New("invalid source map: incomplete segment in mappings")
+	}
+	return fields, nil
+}
+
+// sourceMaps keeps the source maps by the names of the generated files.
+type sourceMaps map[string]*sourceMap
+
+//nolint:gochecknoglobals
+var positionPattern = regexp.MustCompile(`([^\s()]+):(\d+):(\d+)`)
+
+// rewrite replaces the generated positions in the message of the error with the original
+// ones and collects the original source lines of them, errors.Cause of the returned error
+// is still the original error.
+func (sms sourceMaps) rewrite(err error) error {
+	if err == nil || len(sms) == 0 {
+		return err
+	}
+	msg, cause := err.Error(), err
+	if e, ok := err.(*scriptError); ok {
+		if e.mapped {
+			return err
+		}
+		cause = e.cause
+	}
+
+	var source []string
+	rewritten := positionPattern.ReplaceAllStringFunc(msg, func(s string) string {
+		parts := positionPattern.FindStringSubmatch(s)
+		sm := sms[parts[1]]
+		if sm == nil {
+			return s
+		}
+		line, _ := strconv.Atoi(parts[2])
+		column, _ := strconv.Atoi(parts[3])
+		filename, line, column, ok := sm.find(line, column)
+		if !ok {
+			return s
+		}
+		position := filename + ":" + strconv.Itoa(line) + ":" + strconv.Itoa(column)
+		if text := sm.sourceLine(filename, line); text != "" {
+			source = append(source, position+": "+strings.TrimSpace(text))
+		}
+		return position
+	})
+	if rewritten == msg {
+		return err
+	}
+	return &scriptError{cause: cause, msg: rewritten, source: source, mapped: true}
+}
+
+// scriptError replaces the message of the error thrown by the script, errors.Cause returns
+// the original error.
+type scriptError struct {
+	cause  error
+	msg    string
+	source []string
+	mapped bool
+}
+
+func (e *scriptError) Error() string { return e.msg }
+func (e *scriptError) Cause() error  { return e.cause }
+func (e *scriptError) Unwrap() error { return e.cause }
+
+// errorSource returns the original source lines of the positions in the error.
+func errorSource(err error) []string {
+	var e *scriptError
+	if errors.As(err, &e) {
+		return e.source
+	}
+	return nil
+}
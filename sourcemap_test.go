@@ -0,0 +1,80 @@
+package k8
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceMappedErrors(t *testing.T) {
+	// minify the script into one line with an inline source map.
+	result := api.Transform(`
+exports.default = function(args) {
+	if (args.loop) {
+		for (;;) {}
+	}
+	throw new Error("aaaa");
+};
+`, api.TransformOptions{
+		Loader:           api.LoaderJS,
+		Sourcefile:       "/src/original.js",
+		Sourcemap:        api.SourceMapInline,
+		MinifyWhitespace: true,
+	})
+	require.Empty(t, result.Errors)
+
+	ctx := context.Background()
+	b, err := getSimpleBuilder("/script.js", string(result.Code))
+	require.NoError(t, err)
+	r, err := b.Build(ctx, nil)
+	require.NoError(t, err)
+
+	t.Run("Exception", func(t *testing.T) {
+		_, err := r.RunDefaultMethod(ctx, map[string]interface{}{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "/src/original.js:6:")
+		assert.NotContains(t, err.Error(), "/script.js")
+		require.Len(t, errorSource(err), 1)
+		assert.Contains(t, errorSource(err)[0], `throw new Error("aaaa");`)
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		timer := time.AfterFunc(100*time.Millisecond, func() {
+			r.Runtime.Interrupt(errInterrupt)
+		})
+		defer timer.Stop()
+
+		_, err := r.RunDefaultMethod(ctx, map[string]interface{}{"loop": true})
+		require.Error(t, err)
+		_, ok := errors.Cause(err).(lib.TimeoutError)
+		assert.True(t, ok)
+		assert.Contains(t, err.Error(), "\tat /src/original.js:")
+		assert.NotContains(t, err.Error(), "/script.js")
+	})
+}
+
+func TestSourceMapFind(t *testing.T) {
+	// generated from "a;\nb;" => "a;b;" with two segments in the first line.
+	sm, err := parseSourceMap([]byte(`{"version":3,"sources":["in.js"],"mappings":"AAAA,EACA"}`))
+	require.NoError(t, err)
+
+	source, line, column, ok := sm.find(1, 1)
+	assert.True(t, ok)
+	assert.Equal(t, "in.js", source)
+	assert.Equal(t, 1, line)
+	assert.Equal(t, 1, column)
+
+	_, line, _, ok = sm.find(1, 4)
+	assert.True(t, ok)
+	assert.Equal(t, 2, line)
+
+	_, _, _, ok = sm.find(2, 1)
+	assert.False(t, ok)
+}
@@ -0,0 +1,107 @@
+package k8
+
+import (
+	"bytes"
+	"context"
+	htmltemplate "html/template"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+	"golang.org/x/tools/godoc/vfs"
+)
+
+// executor is either a *texttemplate.Template or a *htmltemplate.Template.
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// isHTMLTemplate returns true if the file is rendered by html/template, the values are
+// escaped by their context in the html.
+func isHTMLTemplate(filename string) bool {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// templateCache keeps the compiled templates, it lives as long as the builder, so the
+// templates are compiled again after a reload.
+type templateCache struct {
+	sfs       *scriptFS
+	mu        sync.Mutex
+	templates map[string]executor
+}
+
+func (cache *templateCache) get(ctx context.Context, name string) (executor, error) {
+	filename, err := cache.sfs.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if t, ok := cache.templates[filename]; ok {
+		return t, nil
+	}
+	data, err := vfs.ReadFile(cache.sfs.fs, filename)
+	if err != nil {
+		return nil, err
+	}
+	var t executor
+	if isHTMLTemplate(filename) {
+		tmpl, err := htmltemplate.New(path.Base(filename)).Parse(string(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "parse template '"+filename+"'")
+		}
+		t = tmpl
+	} else {
+		tmpl, err := texttemplate.New(path.Base(filename)).Parse(string(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "parse template '"+filename+"'")
+		}
+		t = tmpl
+	}
+	cache.templates[filename] = t
+	return t, nil
+}
+
+// TemplateModule exposes the templates under the prefixes of the namespace as k8.template,
+// the "*.html" and "*.htm" files are rendered by html/template, the others such as "*.txt"
+// and "*.md" by text/template, e.g.
+//
+//	var body = k8.template.render("/templates/mail.html", {user: user});
+//	return k8.response(body, {contentType: "text/html; charset=utf-8"});
+//
+// The templates are compiled once and cached until the scripts are reloaded.
+func TemplateModule(fs vfs.NameSpace, prefixes []string) Module {
+	cache := &templateCache{
+		sfs:       newScriptFS(fs, prefixes),
+		templates: map[string]executor{},
+	}
+	return ModuleFunc(func(rt *gojs.Runtime) interface{} {
+		return map[string]interface{}{
+			"render": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+				t, err := cache.get(ctx, call.Argument(0).String())
+				if err != nil {
+					panic(rt.NewGoError(err))
+				}
+				var data interface{}
+				if v := call.Argument(1); !goja.IsUndefined(v) && !goja.IsNull(v) {
+					data = v.Export()
+				}
+				var buf bytes.Buffer
+				if err := t.Execute(&buf, data); err != nil {
+					panic(rt.NewGoError(err))
+				}
+				return rt.ToValue(buf.String())
+			},
+		}
+	})
+}
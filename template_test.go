@@ -0,0 +1,79 @@
+package k8
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/loong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/godoc/vfs"
+	"golang.org/x/tools/godoc/vfs/mapfs"
+)
+
+func TestTemplateModule(t *testing.T) {
+	ns := vfs.NameSpace{}
+	ns.Bind("/", mapfs.New(map[string]string{
+		"templates/mail.html": `<p>Hello {{.name}}</p>`,
+		"templates/report.md": `# {{.title}}{{range .items}}
+- {{.}}{{end}}`,
+		"templates/broken.txt": `{{.name`,
+		"secret/a.txt":         `secret`,
+	}), "/", vfs.BindReplace)
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	b.RegisterModule("template", TemplateModule(ns, []string{"/templates"}))
+	require.NoError(t, b.Compile("/render.js", `exports.meta = {id: "render"};
+exports.default = function(args) { return k8.template.render(args.name, args.data); };`))
+	require.NoError(t, b.Compile("/page.js", `exports.meta = {id: "page"};
+exports.default = function(args) {
+	return k8.response(k8.template.render("/templates/mail.html", args), {
+		contentType: "text/html; charset=utf-8",
+		headers: {"X-Page": "mail"}
+	});
+};`))
+	r, err := b.Build(context.Background(), nil)
+	require.NoError(t, err)
+
+	render := func(name string, data interface{}) (interface{}, error) {
+		return r.RunMethod(context.Background(), "render", map[string]interface{}{"name": name, "data": data})
+	}
+	v, err := render("/templates/mail.html", map[string]interface{}{"name": "<b>k8</b>"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Hello &lt;b&gt;k8&lt;/b&gt;</p>", v)
+	v, err = render("/templates/report.md", map[string]interface{}{"title": "<Report>", "items": []interface{}{"a", "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, "# <Report>\n- a\n- b", v)
+
+	_, err = render("/templates/broken.txt", nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "parse template '/templates/broken.txt'")
+	}
+	_, err = render("/templates/../secret/a.txt", nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ErrPathTraversal.Error())
+	}
+	_, err = render("/secret/a.txt", nil)
+	assert.Error(t, err)
+
+	engine := loong.New()
+	require.NoError(t, RegisterHandlers(engine, b, ServerOptions{PoolSize: 1}))
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/k8/page?name=k8")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "mail", resp.Header.Get("X-Page"))
+	assert.Equal(t, "<p>Hello k8</p>", string(body))
+}
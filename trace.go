@@ -0,0 +1,311 @@
+package k8
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/loong"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracers of k8.
+const instrumentationName = "github.com/runner-mei/k8"
+
+// traceScope keeps the active spans of an invocation, the last one is the parent of the
+// spans created by the script and the outbound requests.
+type traceScope struct {
+	tracer trace.Tracer
+	spans  []trace.Span
+}
+
+func (scope *traceScope) current() trace.Span {
+	return scope.spans[len(scope.spans)-1]
+}
+
+// context returns a context with the current span.
+func (scope *traceScope) context() context.Context {
+	return trace.ContextWithSpan(context.Background(), scope.current())
+}
+
+func (scope *traceScope) push(name string) trace.Span {
+	_, span := scope.tracer.Start(scope.context(), name)
+	scope.spans = append(scope.spans, span)
+	return span
+}
+
+func (scope *traceScope) pop() {
+	scope.current().End()
+	scope.spans = scope.spans[:len(scope.spans)-1]
+}
+
+type traceScopeKey struct{}
+
+func (key *traceScopeKey) String() string {
+	return "trace-scope"
+}
+
+//nolint:gochecknoglobals
+var ctxKeyTraceScope = &traceScopeKey{}
+
+func withTraceScope(ctx context.Context, scope *traceScope) context.Context {
+	return context.WithValue(ctx, ctxKeyTraceScope, scope)
+}
+
+func getTraceScope(ctx context.Context) *traceScope {
+	if ctx == nil {
+		return nil
+	}
+	v := ctx.Value(ctxKeyTraceScope)
+	if v == nil {
+		return nil
+	}
+	return v.(*traceScope)
+}
+
+// startSpan starts a span as the child of the span in the context. If tracer is nil, the
+// tracer provider of the parent span is used, or the global one if there is no parent.
+func startSpan(ctx context.Context, tracer trace.Tracer, name string) (trace.Span, context.Context) {
+	if tracer == nil {
+		if parent := trace.SpanFromContext(ctx); parent.SpanContext().IsValid() {
+			tracer = parent.TracerProvider().Tracer(instrumentationName)
+		} else {
+			tracer = otel.Tracer(instrumentationName)
+		}
+	}
+	ctx, span := tracer.Start(ctx, name)
+	return span, ctx
+}
+
+// startServerSpan starts the span of the incoming request, the trace context is extracted
+// from the headers by the global propagator unless there is a span in the context already.
+func startServerSpan(c *loong.Context, tracer trace.Tracer, name string) (trace.Span, context.Context) {
+	ctx := c.StdContext
+	if ctx == nil {
+		ctx = c.Request().Context()
+	}
+	if trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return startSpan(ctx, tracer, name)
+	}
+
+	req := c.Request()
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(req.Header))
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String())))
+	return span, ctx
+}
+
+// setSpanError marks the span as failed.
+func setSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// attributeOf converts a value of the script to an attribute of the span.
+func attributeOf(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case nil:
+		return attribute.String(key, "")
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
+
+// tracingTransport creates a span for each outbound request made by the script, and
+// injects the trace context into the headers.
+type tracingTransport struct {
+	base  http.RoundTripper
+	scope *traceScope
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the current span of the script, such as the one of k8.trace.span, is the parent,
+	// the context of the request only has the span of the invocation.
+	ctx := req.Context()
+	tracer := otel.Tracer(instrumentationName)
+	if t.scope != nil {
+		ctx = trace.ContextWithSpan(ctx, t.scope.current())
+		tracer = t.scope.tracer
+	}
+
+	ctx, span := tracer.Start(ctx, "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String())))
+	defer span.End()
+
+	req = req.Clone(req.Context())
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		setSpanError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	return resp, nil
+}
+
+// traceModule is exposed as k8.trace:
+//
+//	k8.trace.span(name, fn)            runs fn in a child span and returns its result
+//	k8.trace.setAttribute(key, value)  sets the attribute of the current span
+//	k8.trace.startSpan(name)           starts a child span, it must be finished by span.finish()
+//
+// All of them do nothing if the invocation isn't traced.
+func traceModule(rt *gojs.Runtime) interface{} {
+	return map[string]interface{}{
+		"span": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			fn, ok := goja.AssertFunction(call.Argument(1))
+			if !ok {
+				panic(rt.NewTypeError("k8.trace.span: the second argument must be a function"))
+			}
+
+			scope := getTraceScope(ctx)
+			if scope == nil {
+				v, err := fn(goja.Undefined())
+				return throwIfError(rt, v, err)
+			}
+
+			span := scope.push(call.Argument(0).String())
+			defer scope.pop()
+
+			v, err := fn(goja.Undefined())
+			if err != nil {
+				setSpanError(span, err)
+			}
+			return throwIfError(rt, v, err)
+		},
+		"setAttribute": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			if scope := getTraceScope(ctx); scope != nil {
+				scope.current().SetAttributes(attributeOf(call.Argument(0).String(), call.Argument(1).Export()))
+			}
+			return goja.Undefined()
+		},
+		"startSpan": func(ctx context.Context, call goja.FunctionCall) goja.Value {
+			var span trace.Span
+			if scope := getTraceScope(ctx); scope != nil {
+				_, span = scope.tracer.Start(scope.context(), call.Argument(0).String())
+			}
+
+			obj := rt.NewObject()
+			_ = obj.Set("setAttribute", func(call goja.FunctionCall) goja.Value {
+				if span != nil {
+					span.SetAttributes(attributeOf(call.Argument(0).String(), call.Argument(1).Export()))
+				}
+				return goja.Undefined()
+			})
+			_ = obj.Set("finish", func(call goja.FunctionCall) goja.Value {
+				if span != nil {
+					span.End()
+					span = nil
+				}
+				return goja.Undefined()
+			})
+			return obj
+		},
+	}
+}
+
+// throwIfError rethrows the error returned by a javascript function called in a native
+// function.
+func throwIfError(rt *gojs.Runtime, v goja.Value, err error) goja.Value {
+	if err == nil {
+		return v
+	}
+	switch e := err.(type) {
+	case *goja.Exception:
+		panic(e)
+	case *goja.InterruptedError:
+		panic(e)
+	}
+	panic(rt.NewGoError(err))
+}
+
+// A RecordedSpan is a finished span kept by a SpanRecorder.
+type RecordedSpan struct {
+	Name       string
+	TraceID    trace.TraceID
+	SpanID     trace.SpanID
+	ParentID   trace.SpanID
+	Attributes map[string]interface{}
+	Start      time.Time
+	End        time.Time
+}
+
+// A SpanRecorder is an in-memory tracer provider of OpenTelemetry, it keeps the finished
+// spans so that the tests of the scripts can assert on them, e.g.
+//
+//	recorder := k8.NewSpanRecorder()
+//	builder.SetTracerProvider(recorder.TracerProvider())
+//	// ... invoke the methods
+//	spans := recorder.Spans()
+type SpanRecorder struct {
+	exporter *tracetest.InMemoryExporter
+	provider *sdktrace.TracerProvider
+}
+
+// NewSpanRecorder creates an empty recorder.
+func NewSpanRecorder() *SpanRecorder {
+	exporter := tracetest.NewInMemoryExporter()
+	return &SpanRecorder{
+		exporter: exporter,
+		provider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+	}
+}
+
+// TracerProvider returns the tracer provider recording the spans.
+func (recorder *SpanRecorder) TracerProvider() trace.TracerProvider {
+	return recorder.provider
+}
+
+// Spans returns the finished spans in the order they are finished.
+func (recorder *SpanRecorder) Spans() []RecordedSpan {
+	finished := recorder.exporter.GetSpans()
+	spans := make([]RecordedSpan, 0, len(finished))
+	for _, span := range finished {
+		attributes := make(map[string]interface{}, len(span.Attributes))
+		for _, kv := range span.Attributes {
+			attributes[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		spans = append(spans, RecordedSpan{
+			Name:       span.Name,
+			TraceID:    span.SpanContext.TraceID(),
+			SpanID:     span.SpanContext.SpanID(),
+			ParentID:   span.Parent.SpanID(),
+			Attributes: attributes,
+			Start:      span.StartTime,
+			End:        span.EndTime,
+		})
+	}
+	return spans
+}
+
+// Reset drops the recorded spans.
+func (recorder *SpanRecorder) Reset() {
+	recorder.exporter.Reset()
+}
@@ -0,0 +1,149 @@
+package k8
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/runner-mei/gojs/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fetchModule is a tiny http client, it is enough to test the outbound requests.
+func fetchModule(rt *gojs.Runtime) interface{} {
+	return func(ctx context.Context, call goja.FunctionCall) goja.Value {
+		req, err := http.NewRequest(http.MethodGet, call.Argument(0).String(), nil)
+		if err != nil {
+			panic(rt.NewGoError(err))
+		}
+		resp, err := lib.GetState(ctx).Transport.RoundTrip(req)
+		if err != nil {
+			panic(rt.NewGoError(err))
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			panic(rt.NewGoError(err))
+		}
+		return rt.ToValue(string(body))
+	}
+}
+
+func TestTrace(t *testing.T) {
+	recorder := NewSpanRecorder()
+
+	b, err := NewBuilder(&gojs.RuntimeOptions{})
+	require.NoError(t, err)
+	b.SetTracerProvider(recorder.TracerProvider())
+	require.NoError(t, b.Compile("/a.js", `module.exports.meta = {id: 'a'};
+		module.exports.default = function(args) {
+			k8.trace.setAttribute("a", args.a);
+			return k8.trace.span("child", function() {
+				k8.trace.setAttribute("b", 2);
+				var s = k8.trace.startSpan("manual");
+				s.setAttribute("c", 3);
+				s.finish();
+				return 1;
+			});
+		}`))
+
+	ctx := context.Background()
+	pool := NewPool(1, nil)
+	r, err := b.Build(ctx, nil)
+	require.NoError(t, err)
+	pool.Add(r)
+
+	ctx, root := b.Tracer().Start(ctx, "root")
+
+	r, err = pool.Get(ctx)
+	require.NoError(t, err)
+	result, err := r.RunMethod(ctx, "a", map[string]interface{}{"a": "x"})
+	pool.Put(r)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result)
+	root.End()
+
+	spans := map[string]RecordedSpan{}
+	for _, span := range recorder.Spans() {
+		spans[span.Name] = span
+	}
+	require.Len(t, spans, 5)
+
+	rootID := spans["root"].SpanID
+	assert.Equal(t, rootID, spans["k8.pool.acquire"].ParentID)
+	assert.Equal(t, rootID, spans["k8.run"].ParentID)
+	assert.Equal(t, "a", spans["k8.run"].Attributes["k8.method"])
+	assert.Equal(t, "x", spans["k8.run"].Attributes["a"])
+	assert.Equal(t, spans["k8.run"].SpanID, spans["child"].ParentID)
+	assert.Equal(t, int64(2), spans["child"].Attributes["b"])
+	assert.Equal(t, spans["child"].SpanID, spans["manual"].ParentID)
+	assert.Equal(t, int64(3), spans["manual"].Attributes["c"])
+
+	t.Run("Transport", func(t *testing.T) {
+		recorder.Reset()
+		propagator := otel.GetTextMapPropagator()
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		defer otel.SetTextMapPropagator(propagator)
+
+		var header http.Header
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+		}))
+		defer srv.Close()
+
+		_, parent := b.Tracer().Start(context.Background(), "parent")
+		transport := &tracingTransport{scope: &traceScope{tracer: b.Tracer(), spans: []trace.Span{parent}}}
+		client := &http.Client{Transport: transport}
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		parent.End()
+
+		spans := recorder.Spans()
+		require.Len(t, spans, 2)
+		assert.Equal(t, "HTTP GET", spans[0].Name)
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[0].ParentID)
+		assert.Equal(t, int64(http.StatusOK), spans[0].Attributes["http.status_code"])
+		assert.Contains(t, header.Get("Traceparent"), spans[0].SpanID.String())
+	})
+
+	t.Run("RequestInSpan", func(t *testing.T) {
+		recorder.Reset()
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+		defer srv.Close()
+
+		b.RegisterModule("fetch", ModuleFunc(fetchModule))
+		require.NoError(t, b.Compile("/b.js", `module.exports.meta = {id: 'b'};
+			module.exports.default = function(url) {
+				return k8.trace.span("fetch", function() {
+					return k8.fetch(url);
+				});
+			}`))
+		r, err := b.Build(context.Background(), nil)
+		require.NoError(t, err)
+
+		ctx := lib.WithState(context.Background(), &lib.State{Transport: http.DefaultTransport})
+		result, err := r.RunMethod(ctx, "b", srv.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", result)
+
+		spans := map[string]RecordedSpan{}
+		for _, span := range recorder.Spans() {
+			spans[span.Name] = span
+		}
+		require.Contains(t, spans, "HTTP GET")
+		assert.Equal(t, spans["fetch"].SpanID, spans["HTTP GET"].ParentID)
+		assert.Equal(t, spans["k8.run"].SpanID, spans["fetch"].ParentID)
+	})
+}
@@ -0,0 +1,57 @@
+package k8
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/pkg/errors"
+	"github.com/runner-mei/gojs"
+)
+
+func isTypeScript(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".ts")
+}
+
+// transpileTypeScript converts the TypeScript source to JavaScript, the returned source
+// map maps the positions of the JavaScript code back to the TypeScript source. The code
+// is ES5 in the base compatibility mode, the syntax which esbuild cannot lower to ES5,
+// such as let and const, is rejected.
+func transpileTypeScript(filename, code string, compatMode gojs.CompatibilityMode) (string, *sourceMap, error) {
+	target := api.ES2015
+	if compatMode == gojs.CompatibilityModeBase {
+		target = api.ES5
+	}
+	result := api.Transform(code, api.TransformOptions{
+		Loader:     api.LoaderTS,
+		Sourcefile: filename,
+		Sourcemap:  api.SourceMapExternal,
+		Target:     target,
+		// the exports of the ES modules become the properties of "module.exports".
+		Format: api.FormatCommonJS,
+	})
+	if len(result.Errors) > 0 {
+		messages := make([]string, 0, len(result.Errors))
+		for _, msg := range result.Errors {
+			if msg.Location == nil {
+				messages = append(messages, msg.Text)
+				continue
+			}
+			messages = append(messages, msg.Location.File+":"+
+				strconv.Itoa(msg.Location.Line)+":"+
+				strconv.Itoa(msg.Location.Column+1)+": "+msg.Text)
+		}
+		msg := strings.Join(messages, "\n")
+		if target == api.ES5 && strings.Contains(msg, "configured target environment") {
+			msg += "\nthe base compatibility mode only runs ES5, use var instead of let and const, " +
+				"or use the extended compatibility mode"
+		}
+		return "", nil, errors.New(msg)
+	}
+
+	sm, err := parseSourceMap(result.Map)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(result.Code), sm, nil
+}
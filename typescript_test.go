@@ -0,0 +1,108 @@
+package k8
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeScript(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Run", func(t *testing.T) {
+		b, err := getSimpleBuilder("/script.ts", `
+interface Args {
+	name: string;
+}
+
+function greet(args: Args): string {
+	return "hello " + args.name;
+}
+
+exports.default = greet;
+`)
+		require.NoError(t, err)
+		r, err := b.Build(ctx, nil)
+		require.NoError(t, err)
+
+		v, err := r.RunDefaultMethod(ctx, map[string]interface{}{"name": "k8"})
+		require.NoError(t, err)
+		assert.Equal(t, "hello k8", v)
+	})
+	t.Run("ESModule", func(t *testing.T) {
+		b, err := getSimpleBuilder("/script.ts", `
+export var meta = {id: "greet"};
+
+var prefix: string = "hello ";
+
+export default (args: {name: string}): string => {
+	var names: string[] = [args.name];
+	return prefix + names.map((name) => name.toUpperCase()).join(",");
+};
+`, gojs.CompatibilityModeBase)
+		require.NoError(t, err)
+		r, err := b.Build(ctx, nil)
+		require.NoError(t, err)
+
+		v, err := r.RunMethod(ctx, "greet", map[string]interface{}{"name": "k8"})
+		require.NoError(t, err)
+		assert.Equal(t, "hello K8", v)
+	})
+	t.Run("ES2015", func(t *testing.T) {
+		code := `
+export const meta = {id: "greet"};
+
+class Greeter {
+	constructor(private prefix: string) {}
+	greet(name: string): string {
+		let names: string[] = [name];
+		return this.prefix + names.map((name) => name.toUpperCase()).join(",");
+	}
+}
+
+export default (args: {name: string}): string => new Greeter("hello ").greet(args.name);
+`
+		_, err := getSimpleBuilder("/script.ts", code, gojs.CompatibilityModeBase)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "/script.ts:2:8")
+		assert.Contains(t, err.Error(), "the base compatibility mode only runs ES5")
+
+		b, err := getSimpleBuilder("/script.ts", code, gojs.CompatibilityModeExtended)
+		require.NoError(t, err)
+		r, err := b.Build(ctx, nil)
+		require.NoError(t, err)
+
+		v, err := r.RunMethod(ctx, "greet", map[string]interface{}{"name": "k8"})
+		require.NoError(t, err)
+		assert.Equal(t, "hello K8", v)
+	})
+	t.Run("SyntaxError", func(t *testing.T) {
+		_, err := getSimpleBuilder("/script.ts", `
+let a: number = ;
+`)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "/script.ts:2:17")
+	})
+	t.Run("Exception", func(t *testing.T) {
+		b, err := getSimpleBuilder("/script.ts", `
+type Options = {
+	verbose?: boolean;
+};
+
+function run(opts: Options): void {
+	throw new Error("aaaa");
+}
+
+exports.default = run;
+`)
+		require.NoError(t, err)
+		r, err := b.Build(ctx, nil)
+		require.NoError(t, err)
+
+		_, err = r.RunDefaultMethod(ctx, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "/script.ts:7:")
+	})
+}
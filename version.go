@@ -0,0 +1,182 @@
+package k8
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// metaVersion returns the version in the meta description, it is empty if the method
+// isn't versioned.
+func metaVersion(meta map[string]interface{}) string {
+	version, _ := meta["version"].(string)
+	return version
+}
+
+func isDeprecated(meta map[string]interface{}) bool {
+	deprecated, _ := meta["deprecated"].(bool)
+	return deprecated
+}
+
+// methodKey returns the key of the method in Runner.Methods, it is "id@version" if the
+// method is versioned.
+func methodKey(name string, meta map[string]interface{}) string {
+	if version := metaVersion(meta); version != "" {
+		return name + "@" + version
+	}
+	return name
+}
+
+// isStableVersion returns false for the pre-release versions, e.g. "2.0.0-beta".
+func isStableVersion(version string) bool {
+	return !strings.Contains(version, "-")
+}
+
+// compareVersions compares the versions such as "v2", "1.10" and "2.0.0-beta", the dot
+// separated parts are compared as numbers, and a pre-release is older than the release.
+func compareVersions(a, b string) int {
+	a, aPre := splitPrerelease(strings.TrimPrefix(strings.TrimPrefix(a, "v"), "V"))
+	b, bPre := splitPrerelease(strings.TrimPrefix(strings.TrimPrefix(b, "v"), "V"))
+	if c := compareParts(strings.Split(a, "."), strings.Split(b, ".")); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareParts(strings.Split(aPre, "."), strings.Split(bPre, "."))
+}
+
+func splitPrerelease(version string) (string, string) {
+	if idx := strings.IndexByte(version, '-'); idx >= 0 {
+		return version[:idx], version[idx+1:]
+	}
+	return version, ""
+}
+
+func compareParts(a, b []string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		x, y := "0", "0"
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		xi, xerr := strconv.Atoi(x)
+		yi, yerr := strconv.Atoi(y)
+		switch {
+		case x == y:
+			continue
+		case xerr == nil && yerr == nil && xi != yi:
+			if xi < yi {
+				return -1
+			}
+			return 1
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// addDefaultVersions makes "id" the alias of the latest stable version of each versioned
+// method, or the latest version if all versions are pre-releases.
+func addDefaultVersions(methods map[string]Method, filenames map[string]string) error {
+	latest := map[string]string{}
+	for key, method := range methods {
+		version := metaVersion(method.Meta)
+		if version == "" {
+			continue
+		}
+		id := strings.TrimSuffix(key, "@"+version)
+		if _, ok := methods[id]; ok {
+			return errors.New("method '" + id + "' in " + filenames[id] +
+				" has no version, but the version '" + version + "' is declared in " + filenames[key])
+		}
+
+		current, ok := latest[id]
+		if !ok || isNewerDefault(version, current) {
+			latest[id] = version
+		}
+	}
+	for id, version := range latest {
+		methods[id] = methods[id+"@"+version]
+	}
+	return nil
+}
+
+// isNewerDefault returns true if version is preferred to current as the default version.
+func isNewerDefault(version, current string) bool {
+	if isStableVersion(version) != isStableVersion(current) {
+		return isStableVersion(version)
+	}
+	return compareVersions(version, current) > 0
+}
+
+// lookupMethod finds the method by "id" or "id@version", the prefix "v" of the version
+// is optional, e.g. "a@v2" and "a@2" are same.
+func (runner *Runner) lookupMethod(name string) (Method, bool) {
+	if method, ok := runner.Methods[name]; ok {
+		return method, true
+	}
+	idx := strings.LastIndexByte(name, '@')
+	if idx < 0 {
+		return Method{}, false
+	}
+	id, version := name[:idx], name[idx+1:]
+	if strings.HasPrefix(version, "v") || strings.HasPrefix(version, "V") {
+		version = version[1:]
+	} else {
+		version = "v" + version
+	}
+	method, ok := runner.Methods[id+"@"+version]
+	return method, ok
+}
+
+// methodList returns the meta descriptions of all methods, every version of a method is
+// listed with the flags "deprecated" and "default".
+func (runner *Runner) methodList() []map[string]interface{} {
+	keys := make([]string, 0, len(runner.Methods))
+	for key := range runner.Methods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		meta := runner.Methods[key].Meta
+		version := metaVersion(meta)
+		if version == "" {
+			item := make(map[string]interface{}, len(meta)+1)
+			for k, v := range meta {
+				item[k] = v
+			}
+			item["deprecated"] = isDeprecated(meta)
+			results = append(results, item)
+			continue
+		}
+		id := strings.TrimSuffix(key, "@"+version)
+		if id == key {
+			// it is the alias of the default version.
+			continue
+		}
+
+		item := make(map[string]interface{}, len(meta)+2)
+		for k, v := range meta {
+			item[k] = v
+		}
+		item["deprecated"] = isDeprecated(meta)
+		item["default"] = metaVersion(runner.Methods[id].Meta) == version
+		results = append(results, item)
+	}
+	return results
+}
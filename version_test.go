@@ -0,0 +1,107 @@
+package k8
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dop251/goja"
+	"github.com/runner-mei/gojs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1", "v2", -1},
+		{"v2", "2", 0},
+		{"1.10", "1.9", 1},
+		{"2.0.0-beta", "2.0.0", -1},
+		{"2.0.0-beta.2", "2.0.0-beta.10", -1},
+		{"1.0", "1", 0},
+	} {
+		assert.Equal(t, tc.expected, compareVersions(tc.a, tc.b), tc.a+" <=> "+tc.b)
+	}
+}
+
+func buildVersions(t *testing.T, scripts map[string]string) (*Runner, error) {
+	b, err := NewBuilder(&gojs.RuntimeOptions{
+		CompatibilityMode: gojs.CompatibilityModeBase.String(),
+	})
+	require.NoError(t, err)
+	for filename, code := range scripts {
+		require.NoError(t, b.Compile(filename, code))
+	}
+	return b.Build(context.Background(), nil)
+}
+
+func TestMethodVersions(t *testing.T) {
+	ctx := context.Background()
+	r, err := buildVersions(t, map[string]string{
+		"/a_v1.js": `exports.meta = {id: "a", version: "v1", deprecated: true};
+exports.default = function() { return 1; };`,
+		"/a_v2.js": `exports.meta = {id: "a", version: "v2"};
+exports.default = function() { return 2; };`,
+		"/a_v3.js": `exports.meta = {id: "a", version: "v3-beta"};
+exports.default = function() { return 3; };`,
+		"/b.js": `exports.meta = {id: "b"};
+exports.default = function() { return "b"; };`,
+	})
+	require.NoError(t, err)
+
+	for name, expected := range map[string]interface{}{
+		"a":         int64(2),
+		"a@v1":      int64(1),
+		"a@1":       int64(1),
+		"a@v3-beta": int64(3),
+		"b":         "b",
+	} {
+		v, err := r.RunMethod(ctx, name, goja.Undefined())
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, expected, v, name)
+		}
+	}
+	_, err = r.RunMethod(ctx, "a@v4", goja.Undefined())
+	assert.Equal(t, ErrMethodMissing, err)
+
+	var listed []string
+	for _, meta := range r.methodList() {
+		item := meta["id"].(string)
+		if version := metaVersion(meta); version != "" {
+			item += "@" + version
+			if meta["default"].(bool) {
+				item += " default"
+			}
+		}
+		// every method has the flag, including the ones without a version.
+		if meta["deprecated"].(bool) {
+			item += " deprecated"
+		}
+		listed = append(listed, item)
+	}
+	assert.Equal(t, []string{"a@v1 deprecated", "a@v2 default", "a@v3-beta", "b"}, listed)
+}
+
+func TestMethodVersionNumber(t *testing.T) {
+	_, err := buildVersions(t, map[string]string{
+		"/a.js": `exports.meta = {id: "a", version: 1.10};
+exports.default = function() { return 1; };`,
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "version must be a string")
+	}
+}
+
+func TestMethodVersionsMixed(t *testing.T) {
+	_, err := buildVersions(t, map[string]string{
+		"/a.js": `exports.meta = {id: "a"};
+exports.default = function() { return 1; };`,
+		"/a_v2.js": `exports.meta = {id: "a", version: "v2"};
+exports.default = function() { return 2; };`,
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "method 'a' in /a.js has no version")
+	}
+}